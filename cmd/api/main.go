@@ -1,168 +1,167 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/SirClappington/bouncerate-backendv2/internal/errors"
+	"github.com/SirClappington/bouncerate-backendv2/internal/server"
 	"github.com/SirClappington/bouncerate-backendv2/internal/services"
-	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
 
-var (
-	competitorService *services.CompetitorService
-	firebaseService   *services.FirebaseService
-	analysisService   *services.AnalysisService
-	logger            *log.Logger
-)
+// config is everything main reads from the environment.
+type config struct {
+	Server          server.Config
+	Store           services.StoreConfig
+	FirecrawlAPIKey string
+	FirecrawlURL    string
+	CrawlTimeout    time.Duration
+	Cache           services.CacheConfig
+	PlacesAPIKey    string
+	PlacesURL       string
+	RateLimits      services.RateLimitConfig
+	SearchWorkers   int
+	// AuthDisabled serves the API without authentication, for local
+	// development against the local or memory storage backend.
+	AuthDisabled bool
+}
 
-func init() {
-	// Load .env file
-	if err := godotenv.Load(); err != nil {
-		log.Printf("No .env file found: %v", err)
+func loadConfig() config {
+	cfg := config{
+		Server: server.Config{
+			Port: os.Getenv("PORT"),
+		},
+		Store: services.StoreConfig{
+			Backend:             os.Getenv("STORAGE_BACKEND"),
+			CredentialsFilePath: os.Getenv("FIREBASE_CREDENTIALS_FILE"),
+			BucketName:          os.Getenv("FIREBASE_BUCKET_NAME"),
+			LocalDir:            os.Getenv("STORAGE_LOCAL_DIR"),
+		},
+		FirecrawlAPIKey: os.Getenv("FIRECRAWL_API_KEY"),
+		FirecrawlURL:    os.Getenv("FIRECRAWL_BASE_URL"),
+		PlacesAPIKey:    os.Getenv("GOOGLE_PLACES_API_KEY"),
+		PlacesURL:       os.Getenv("GOOGLE_PLACES_BASE_URL"),
 	}
 
-	// Initialize logger
-	logger = log.New(os.Stdout, "[BOUNCERATE] ", log.LstdFlags)
-
-	// Initialize services
-	var err error
-	competitorService, err = services.NewCompetitorService(
-		os.Getenv("FIRECRAWL_API_KEY"),
-		os.Getenv("FIRECRAWL_BASE_URL"),
-		os.Getenv("GOOGLE_PLACES_API_KEY"),
-		os.Getenv("FIREBASE_CREDENTIALS_FILE"),
-		os.Getenv("FIREBASE_BUCKET_NAME"),
-		logger,
-	)
-	if err != nil {
-		log.Fatalf("Failed to initialize competitor service: %v", err)
+	// An unset or invalid timeout or TTL falls back to the service default
+	cfg.CrawlTimeout, _ = time.ParseDuration(os.Getenv("FIRECRAWL_CRAWL_TIMEOUT"))
+	cfg.Cache.SearchTTL, _ = time.ParseDuration(os.Getenv("SEARCH_CACHE_TTL"))
+	cfg.Cache.SiteTTL, _ = time.ParseDuration(os.Getenv("SITE_CACHE_TTL"))
+	cfg.Cache.PageTTL, _ = time.ParseDuration(os.Getenv("PAGE_CACHE_TTL"))
+
+	// Budgets are written as "<requests>/<duration>"; unset ones use the
+	// service defaults
+	cfg.RateLimits = services.RateLimitConfig{
+		Firecrawl: rateBudget("FIRECRAWL_RATE_LIMIT"),
+		Places:    rateBudget("GOOGLE_PLACES_RATE_LIMIT"),
+		Domain:    rateBudget("DOMAIN_RATE_LIMIT"),
 	}
 
-	firebaseService, err = services.NewFirebaseService(
-		os.Getenv("FIREBASE_CREDENTIALS_FILE"),
-		os.Getenv("FIREBASE_BUCKET_NAME"),
-		logger,
-	)
-	if err != nil {
-		log.Fatalf("Failed to initialize firebase service: %v", err)
+	// Comma separated, such as "uploads/,exports/"
+	for _, prefix := range strings.Split(os.Getenv("OBJECT_PREFIXES"), ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			cfg.Server.ObjectPrefixes = append(cfg.Server.ObjectPrefixes, prefix)
+		}
 	}
+	// An unset or invalid limit falls back to the server default
+	cfg.Server.MaxUploadBytes, _ = strconv.ParseInt(os.Getenv("MAX_UPLOAD_BYTES"), 10, 64)
 
-	analysisService = services.NewAnalysisService(firebaseService, logger)
-}
+	cfg.AuthDisabled, _ = strconv.ParseBool(os.Getenv("AUTH_DISABLED"))
 
-func handleError(c *gin.Context, err error) {
-	if apiErr, ok := err.(*errors.APIError); ok {
-		switch apiErr.Type {
-		case errors.ErrorTypeValidation:
-			c.JSON(http.StatusBadRequest, apiErr)
-		case errors.ErrorTypeNotFound:
-			c.JSON(http.StatusNotFound, apiErr)
-		case errors.ErrorTypeExternal:
-			c.JSON(http.StatusServiceUnavailable, apiErr)
-		case errors.ErrorTypeUnauthorized:
-			c.JSON(http.StatusUnauthorized, apiErr)
-		default:
-			c.JSON(http.StatusInternalServerError, apiErr)
-		}
-		return
+	searchWorkers, err := strconv.Atoi(os.Getenv("SEARCH_WORKERS"))
+	if err != nil || searchWorkers < 1 {
+		searchWorkers = 2 // Default worker count if not specified
 	}
+	cfg.SearchWorkers = searchWorkers
 
-	// Handle unknown errors
-	c.JSON(http.StatusInternalServerError, errors.NewInternalError(err))
+	return cfg
 }
 
-func main() {
-	r := gin.Default()
-
-	r.GET("/", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"message": "Welcome to Bounce Rate API!"})
-	})
+func rateBudget(name string) services.RateBudget {
+	value := os.Getenv(name)
+	if value == "" {
+		return services.RateBudget{}
+	}
 
-	r.POST("/upload", func(c *gin.Context) {
-		filePath := c.PostForm("file_path")
-		objectName := c.PostForm("object_name")
+	budget, err := services.ParseRateBudget(value)
+	if err != nil {
+		log.Printf("Ignoring %s: %v", name, err)
+		return services.RateBudget{}
+	}
+	return budget
+}
 
-		if err := firebaseService.UploadFile(c.Request.Context(), filePath, objectName); err != nil {
-			handleError(c, err)
-			return
-		}
+func main() {
+	// Load .env file
+	if err := godotenv.Load(); err != nil {
+		log.Printf("No .env file found: %v", err)
+	}
 
-		c.JSON(http.StatusOK, gin.H{"message": "File uploaded successfully"})
-	})
+	cfg := loadConfig()
+	logger := log.New(os.Stdout, "[BOUNCERATE] ", log.LstdFlags)
 
-	r.GET("/download", func(c *gin.Context) {
-		objectName := c.Query("object_name")
-		destPath := c.Query("dest_path")
+	store, err := services.NewStore(cfg.Store, logger)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer store.Close()
 
-		if objectName == "" || destPath == "" {
-			c.JSON(400, gin.H{"error": "object_name and dest_path query parameters are required"})
-			return
-		}
+	rateLimits := services.NewRateLimits(cfg.RateLimits)
 
-		if err := firebaseService.DownloadFile(c.Request.Context(), objectName, destPath); err != nil {
-			handleError(c, err)
-			return
-		}
+	firecrawlClient, err := services.NewFirecrawlClient(cfg.FirecrawlAPIKey, cfg.FirecrawlURL, cfg.CrawlTimeout, rateLimits, services.DefaultRetryPolicy())
+	if err != nil {
+		log.Fatalf("Failed to initialize Firecrawl client: %v", err)
+	}
 
-		c.JSON(http.StatusOK, gin.H{"message": "File downloaded successfully"})
-	})
+	placesClient, err := services.NewPlacesClient(cfg.PlacesAPIKey, cfg.PlacesURL, rateLimits.Places, services.DefaultRetryPolicy())
+	if err != nil {
+		log.Fatalf("Failed to initialize Places client: %v", err)
+	}
 
-	r.POST("/analyze-purchase", func(c *gin.Context) {
-		var request struct {
-			ProductType   string  `json:"productType" binding:"required"`
-			PurchasePrice float64 `json:"purchasePrice" binding:"required"`
-			Location      string  `json:"location" binding:"required"`
-		}
+	locations := services.NewLocationResolver(placesClient, store, logger)
+	competitorService := services.NewCompetitorService(firecrawlClient, placesClient, locations, store, cfg.Cache, logger)
 
-		if err := c.ShouldBindJSON(&request); err != nil {
-			c.JSON(400, gin.H{"error": err.Error()})
-			return
-		}
+	// Deferred calls run in reverse, so running searches stop before the
+	// clients they use are closed.
+	searchJobService := services.NewSearchJobService(competitorService, cfg.SearchWorkers, 100, logger)
+	defer searchJobService.Close()
 
-		averagePrice, err := analysisService.CalculateAveragePrice(c.Request.Context(), request.Location, request.ProductType)
-		if err != nil {
-			handleError(c, err)
-			return
-		}
+	svc := server.Services{
+		Store:       store,
+		Competitors: competitorService,
+		SearchJobs:  searchJobService,
+		Analysis:    services.NewAnalysisService(store, locations, logger),
+		RateLimits:  rateLimits,
+	}
 
-		breakEvenPoint, err := analysisService.CalculateBreakEvenPoint(request.PurchasePrice, averagePrice)
+	// ID tokens are verified against the Firebase project holding the bucket
+	switch fs, ok := store.(*services.FirebaseService); {
+	case cfg.AuthDisabled:
+		logger.Printf("Authentication is disabled; every route is public")
+	case ok:
+		auth, err := fs.Auth(context.Background())
 		if err != nil {
-			handleError(c, err)
-			return
-		}
-
-		c.JSON(200, gin.H{
-			"message":        "Successfully analyzed purchase",
-			"averagePrice":   averagePrice,
-			"breakEvenPoint": breakEvenPoint,
-		})
-	})
-
-	r.GET("/search", func(c *gin.Context) {
-		location := c.Query("location")
-		if location == "" {
-			c.JSON(400, gin.H{"error": "location query parameter is required"})
-			return
+			log.Fatalf("Failed to initialize authentication: %v", err)
 		}
+		svc.Auth = auth
+		svc.APIKeys = services.NewAPIKeyService(store, logger)
+	default:
+		log.Fatalf("Storage backend %q has no Firebase project to authenticate against; set AUTH_DISABLED=true for local development", cfg.Store.Backend)
+	}
 
-		result, err := competitorService.SearchCompetitors(c.Request.Context(), location)
-		if err != nil {
-			handleError(c, err)
-			return
-		}
+	srv := server.New(cfg.Server, svc, logger)
 
-		c.JSON(200, result)
-	})
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Get the PORT from environment variables
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080" // Default port if not specified
+	if err := srv.Run(ctx); err != nil && err != http.ErrServerClosed {
+		logger.Printf("Server stopped: %v", err)
 	}
-
-	// Start the Gin server on the specified port
-	r.Run(":" + port)
 }
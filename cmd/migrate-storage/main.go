@@ -0,0 +1,85 @@
+// Command migrate-storage rewrites the objects of the storage bucket from the
+// unversioned layout into the current one described in
+// internal/services/keys.go.
+//
+// It reads the same environment as the API. Locations stored before they had
+// canonical keys are geocoded with GOOGLE_PLACES_API_KEY; without it they are
+// reported as skipped. Legacy objects are kept unless -delete-legacy is set.
+//
+// Data stored before authentication was enabled has no owner. Pass -user, or
+// -tenant for a Firebase Auth tenant, to hand every legacy and unscoped
+// location over to that account; otherwise no signed-in user can see it.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/SirClappington/bouncerate-backendv2/internal/services"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report what would be migrated without writing anything")
+	deleteLegacy := flag.Bool("delete-legacy", false, "delete legacy objects once they have been migrated")
+	userID := flag.String("user", "", "Firebase user ID to migrate unowned locations into")
+	tenantID := flag.String("tenant", "", "Firebase Auth tenant ID to migrate unowned locations into")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil {
+		log.Printf("No .env file found: %v", err)
+	}
+	logger := log.New(os.Stderr, "[MIGRATE] ", log.LstdFlags)
+
+	store, err := services.NewStore(services.StoreConfig{
+		Backend:             os.Getenv("STORAGE_BACKEND"),
+		CredentialsFilePath: os.Getenv("FIREBASE_CREDENTIALS_FILE"),
+		BucketName:          os.Getenv("FIREBASE_BUCKET_NAME"),
+		LocalDir:            os.Getenv("STORAGE_LOCAL_DIR"),
+		Retry:               services.DefaultRetryPolicy(),
+	}, logger)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer store.Close()
+
+	migrator, ok := store.(services.Migrator)
+	if !ok {
+		log.Fatalf("Storage backend %T cannot be migrated", store)
+	}
+
+	opts := services.MigrationOptions{DryRun: *dryRun, DeleteLegacy: *deleteLegacy}
+	if *userID != "" || *tenantID != "" {
+		opts.Account = &services.Account{UserID: *userID, TenantID: *tenantID}
+	}
+	if apiKey := os.Getenv("GOOGLE_PLACES_API_KEY"); apiKey != "" {
+		places, err := services.NewPlacesClient(apiKey, os.Getenv("GOOGLE_PLACES_BASE_URL"), nil, services.DefaultRetryPolicy())
+		if err != nil {
+			log.Fatalf("Failed to initialize Places client: %v", err)
+		}
+		// Aliases learned while resolving are only kept for a real run
+		var aliases services.Store = store
+		if *dryRun {
+			aliases = services.NewMemoryStore(logger)
+		}
+		opts.Resolve = services.NewLocationResolver(places, aliases, logger).Resolve
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report, err := migrator.MigrateLayout(ctx, opts)
+	if report != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	}
+	if err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+// Command objects copies files between the local filesystem and the storage
+// bucket. It is the only place object names meet filesystem paths; the API
+// streams uploads and downloads through the request instead.
+//
+// Usage:
+//
+//	objects upload <file> <object name>
+//	objects download <object name> <file>
+//
+// It reads the same storage environment as the API.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/SirClappington/bouncerate-backendv2/internal/services"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: objects upload <file> <object name>\n       objects download <object name> <file>\n")
+	}
+	flag.Parse()
+	if flag.NArg() != 3 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Printf("No .env file found: %v", err)
+	}
+	logger := log.New(os.Stderr, "[OBJECTS] ", log.LstdFlags)
+
+	store, err := services.NewStore(services.StoreConfig{
+		Backend:             os.Getenv("STORAGE_BACKEND"),
+		CredentialsFilePath: os.Getenv("FIREBASE_CREDENTIALS_FILE"),
+		BucketName:          os.Getenv("FIREBASE_BUCKET_NAME"),
+		LocalDir:            os.Getenv("STORAGE_LOCAL_DIR"),
+		Retry:               services.DefaultRetryPolicy(),
+	}, logger)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	defer store.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch flag.Arg(0) {
+	case "upload":
+		err = services.UploadFile(ctx, store, flag.Arg(1), flag.Arg(2))
+	case "download":
+		err = services.DownloadFile(ctx, store, flag.Arg(1), flag.Arg(2))
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("%s failed: %v", flag.Arg(0), err)
+	}
+}
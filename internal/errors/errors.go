@@ -5,11 +5,14 @@ import "fmt"
 type ErrorType string
 
 const (
-	ErrorTypeValidation   ErrorType = "VALIDATION_ERROR"
-	ErrorTypeNotFound     ErrorType = "NOT_FOUND"
-	ErrorTypeExternal     ErrorType = "EXTERNAL_API_ERROR"
-	ErrorTypeInternal     ErrorType = "INTERNAL_ERROR"
-	ErrorTypeUnauthorized ErrorType = "UNAUTHORIZED"
+	ErrorTypeValidation    ErrorType = "VALIDATION_ERROR"
+	ErrorTypeNotFound      ErrorType = "NOT_FOUND"
+	ErrorTypeExternal      ErrorType = "EXTERNAL_API_ERROR"
+	ErrorTypeInternal      ErrorType = "INTERNAL_ERROR"
+	ErrorTypeUnauthorized  ErrorType = "UNAUTHORIZED"
+	ErrorTypeForbidden     ErrorType = "FORBIDDEN"
+	ErrorTypeQuotaExceeded ErrorType = "QUOTA_EXCEEDED"
+	ErrorTypeUnavailable   ErrorType = "SERVICE_UNAVAILABLE"
 )
 
 type APIError struct {
@@ -30,6 +33,42 @@ func NewValidationError(message string) *APIError {
 	}
 }
 
+func NewNotFoundError(message string) *APIError {
+	return &APIError{
+		Type:    ErrorTypeNotFound,
+		Message: message,
+	}
+}
+
+func NewUnauthorizedError(message string) *APIError {
+	return &APIError{
+		Type:    ErrorTypeUnauthorized,
+		Message: message,
+	}
+}
+
+func NewForbiddenError(message string) *APIError {
+	return &APIError{
+		Type:    ErrorTypeForbidden,
+		Message: message,
+	}
+}
+
+func NewQuotaExceededError(message string, details any) *APIError {
+	return &APIError{
+		Type:    ErrorTypeQuotaExceeded,
+		Message: message,
+		Details: details,
+	}
+}
+
+func NewUnavailableError(message string) *APIError {
+	return &APIError{
+		Type:    ErrorTypeUnavailable,
+		Message: message,
+	}
+}
+
 func NewExternalError(service string, err error) *APIError {
 	return &APIError{
 		Type:    ErrorTypeExternal,
@@ -0,0 +1,58 @@
+// Package fakes provides in-process fake Google Places and Firecrawl servers
+// for hermetic tests. Both serve scripted fixtures and support failure
+// injection per endpoint.
+package fakes
+
+import (
+	"net/http"
+	"sync"
+)
+
+// failures counts requests per endpoint and holds the failures scripted for
+// upcoming requests.
+type failures struct {
+	mu       sync.Mutex
+	pending  map[string][]int
+	requests map[string]int
+}
+
+func newFailures() *failures {
+	return &failures{
+		pending:  make(map[string][]int),
+		requests: make(map[string]int),
+	}
+}
+
+// failNext makes the next n requests to endpoint fail with status.
+func (f *failures) failNext(endpoint string, n, status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		f.pending[endpoint] = append(f.pending[endpoint], status)
+	}
+}
+
+// record counts a request to endpoint and, if a failure is scripted for it,
+// writes the failure and returns true.
+func (f *failures) record(w http.ResponseWriter, endpoint string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests[endpoint]++
+	queue := f.pending[endpoint]
+	if len(queue) == 0 {
+		return false
+	}
+	status := queue[0]
+	f.pending[endpoint] = queue[1:]
+	http.Error(w, http.StatusText(status), status)
+	return true
+}
+
+func (f *failures) count(endpoint string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.requests[endpoint]
+}
@@ -0,0 +1,246 @@
+package fakes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/SirClappington/bouncerate-backendv2/internal/services"
+)
+
+// Firecrawl API endpoints served by FirecrawlServer. Crawl status requests
+// are counted under FirecrawlCrawlStatus.
+const (
+	FirecrawlMap         = "/v1/map"
+	FirecrawlScrape      = "/v1/scrape"
+	FirecrawlCrawl       = "/v1/crawl"
+	FirecrawlCrawlStatus = "/v1/crawl/{id}"
+)
+
+// Site is a website fixture. Links are returned by map, CrawlLinks by a
+// crawl of the site.
+type Site struct {
+	Links      []string
+	CrawlLinks []string
+}
+
+// FirecrawlServer fakes the Firecrawl map, scrape and crawl endpoints. Pass
+// BaseURL() to services.NewFirecrawlClient.
+type FirecrawlServer struct {
+	*httptest.Server
+	*failures
+
+	mu            sync.Mutex
+	sites         map[string]Site
+	pages         map[string][]services.ProductSchema
+	crawls        map[string]string
+	crawlPageSize int
+	extractions   int
+}
+
+func NewFirecrawlServer() *FirecrawlServer {
+	s := &FirecrawlServer{
+		failures:      newFailures(),
+		sites:         make(map[string]Site),
+		pages:         make(map[string][]services.ProductSchema),
+		crawls:        make(map[string]string),
+		crawlPageSize: 10,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+FirecrawlMap, s.mapSite)
+	mux.HandleFunc("POST "+FirecrawlScrape, s.scrape)
+	mux.HandleFunc("POST "+FirecrawlCrawl, s.startCrawl)
+	mux.HandleFunc("GET "+FirecrawlCrawlStatus, s.crawlStatus)
+	mux.HandleFunc("DELETE "+FirecrawlCrawlStatus, s.cancelCrawl)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// BaseURL is the API base URL in the form FIRECRAWL_BASE_URL expects.
+func (s *FirecrawlServer) BaseURL() string {
+	return s.URL + "/v1/"
+}
+
+// AddSite registers a website fixture under its URL.
+func (s *FirecrawlServer) AddSite(website string, site Site) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sites[website] = site
+}
+
+// AddPage sets the products extracted when pageURL is scraped.
+func (s *FirecrawlServer) AddPage(pageURL string, products ...services.ProductSchema) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pages[pageURL] = products
+}
+
+// FailNext makes the next n requests to endpoint fail with the HTTP status.
+func (s *FirecrawlServer) FailNext(endpoint string, n, status int) {
+	s.failNext(endpoint, n, status)
+}
+
+// Extractions returns how many scrapes of known pages asked for the extract
+// format, as opposed to only checking the page content.
+func (s *FirecrawlServer) Extractions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.extractions
+}
+
+// Requests returns how many requests endpoint has received.
+func (s *FirecrawlServer) Requests(endpoint string) int {
+	return s.count(endpoint)
+}
+
+func (s *FirecrawlServer) mapSite(w http.ResponseWriter, r *http.Request) {
+	if s.record(w, FirecrawlMap) {
+		return
+	}
+
+	var request struct {
+		URL string `json:"url"`
+	}
+	json.NewDecoder(r.Body).Decode(&request)
+
+	s.mu.Lock()
+	site, ok := s.sites[request.URL]
+	s.mu.Unlock()
+
+	if !ok {
+		writeJSON(w, map[string]any{"success": false, "error": "site not found"})
+		return
+	}
+	writeJSON(w, map[string]any{"success": true, "links": site.Links})
+}
+
+func (s *FirecrawlServer) scrape(w http.ResponseWriter, r *http.Request) {
+	if s.record(w, FirecrawlScrape) {
+		return
+	}
+
+	var request struct {
+		URL     string   `json:"url"`
+		Formats []string `json:"formats"`
+	}
+	json.NewDecoder(r.Body).Decode(&request)
+
+	s.mu.Lock()
+	products, ok := s.pages[request.URL]
+	if ok && slices.Contains(request.Formats, "extract") {
+		s.extractions++
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		http.Error(w, `{"success":false,"error":"page not found"}`, http.StatusNotFound)
+		return
+	}
+
+	data := map[string]any{
+		"metadata": map[string]any{"sourceURL": request.URL, "statusCode": 200},
+	}
+	for _, format := range request.Formats {
+		switch format {
+		case "extract":
+			data["extract"] = map[string]any{"products": products}
+		case "markdown":
+			data["markdown"] = pageMarkdown(products)
+		}
+	}
+	writeJSON(w, map[string]any{"success": true, "data": data})
+}
+
+// pageMarkdown renders a page's products, so the content hash of a page
+// changes exactly when AddPage changes its products.
+func pageMarkdown(products []services.ProductSchema) string {
+	var b strings.Builder
+	for _, product := range products {
+		fmt.Fprintf(&b, "- %s: %s\n", product.Name, product.Price)
+	}
+	return b.String()
+}
+
+func (s *FirecrawlServer) startCrawl(w http.ResponseWriter, r *http.Request) {
+	if s.record(w, FirecrawlCrawl) {
+		return
+	}
+
+	var request struct {
+		URL string `json:"url"`
+	}
+	json.NewDecoder(r.Body).Decode(&request)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sites[request.URL]; !ok {
+		http.Error(w, `{"success":false,"error":"site not found"}`, http.StatusBadRequest)
+		return
+	}
+	id := fmt.Sprintf("crawl-%d", len(s.crawls)+1)
+	s.crawls[id] = request.URL
+	writeJSON(w, map[string]any{"success": true, "id": id, "url": s.URL + "/v1/crawl/" + id})
+}
+
+// crawlStatus reports the crawl as completed straight away and pages the
+// site's crawl links through the skip parameter.
+func (s *FirecrawlServer) crawlStatus(w http.ResponseWriter, r *http.Request) {
+	if s.record(w, FirecrawlCrawlStatus) {
+		return
+	}
+
+	id := r.PathValue("id")
+
+	s.mu.Lock()
+	website, ok := s.crawls[id]
+	site := s.sites[website]
+	pageSize := s.crawlPageSize
+	s.mu.Unlock()
+
+	if !ok {
+		http.Error(w, `{"success":false,"error":"crawl not found"}`, http.StatusNotFound)
+		return
+	}
+
+	skip, _ := strconv.Atoi(r.URL.Query().Get("skip"))
+	end := skip + pageSize
+	if end > len(site.CrawlLinks) {
+		end = len(site.CrawlLinks)
+	}
+	if skip > end {
+		skip = end
+	}
+
+	var data []map[string]any
+	for _, link := range site.CrawlLinks[skip:end] {
+		data = append(data, map[string]any{
+			"links":    []string{link},
+			"metadata": map[string]any{"sourceURL": strings.TrimSuffix(website, "/") + "/"},
+		})
+	}
+
+	response := map[string]any{
+		"status":    "completed",
+		"total":     len(site.CrawlLinks),
+		"completed": len(site.CrawlLinks),
+		"data":      data,
+	}
+	if end < len(site.CrawlLinks) {
+		response["next"] = fmt.Sprintf("%s/v1/crawl/%s?skip=%d", s.URL, id, end)
+	}
+	writeJSON(w, response)
+}
+
+func (s *FirecrawlServer) cancelCrawl(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]any{"success": true, "status": "cancelled"})
+}
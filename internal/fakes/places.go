@@ -0,0 +1,236 @@
+package fakes
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Places API endpoints served by PlacesServer.
+const (
+	PlacesTextSearch = "/maps/api/place/textsearch/json"
+	PlacesDetails    = "/maps/api/place/details/json"
+	PlacesGeocode    = "/maps/api/geocode/json"
+)
+
+// Place is a fixture returned by the fake Places API.
+type Place struct {
+	PlaceID     string
+	Name        string
+	Website     string
+	Address     string
+	Phone       string
+	Lat         float64
+	Lng         float64
+	Rating      float32
+	ReviewCount int
+	Hours       []string
+}
+
+// Locality is a city returned by the fake Geocoding API. State and Country
+// are short names, such as "TX" and "US".
+type Locality struct {
+	PlaceID string
+	City    string
+	State   string
+	Country string
+	Lat     float64
+	Lng     float64
+	// Aliases are the addresses that geocode to the locality, matched
+	// case-insensitively.
+	Aliases []string
+}
+
+// PlacesServer fakes the Places TextSearch and PlaceDetails endpoints and the
+// Geocoding API. Point a maps.Client at it with maps.WithBaseURL(server.URL).
+type PlacesServer struct {
+	*httptest.Server
+	*failures
+
+	mu         sync.Mutex
+	places     []Place
+	localities []Locality
+	pageSize   int
+}
+
+func NewPlacesServer() *PlacesServer {
+	s := &PlacesServer{
+		failures: newFailures(),
+		pageSize: 20,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(PlacesTextSearch, s.textSearch)
+	mux.HandleFunc(PlacesDetails, s.details)
+	mux.HandleFunc(PlacesGeocode, s.geocode)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// AddPlace adds a fixture returned by every text search.
+func (s *PlacesServer) AddPlace(place Place) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.places = append(s.places, place)
+}
+
+// AddLocality adds a city to geocode. Reverse geocoding returns the closest
+// locality to the requested point.
+func (s *PlacesServer) AddLocality(locality Locality) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.localities = append(s.localities, locality)
+}
+
+// SetPageSize sets how many results a text search page holds before a
+// next_page_token is returned.
+func (s *PlacesServer) SetPageSize(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pageSize = n
+}
+
+// FailNext makes the next n requests to endpoint fail with the HTTP status.
+func (s *PlacesServer) FailNext(endpoint string, n, status int) {
+	s.failNext(endpoint, n, status)
+}
+
+// Requests returns how many requests endpoint has received.
+func (s *PlacesServer) Requests(endpoint string) int {
+	return s.count(endpoint)
+}
+
+func (s *PlacesServer) textSearch(w http.ResponseWriter, r *http.Request) {
+	if s.record(w, PlacesTextSearch) {
+		return
+	}
+
+	s.mu.Lock()
+	places := append([]Place(nil), s.places...)
+	pageSize := s.pageSize
+	s.mu.Unlock()
+
+	start, _ := strconv.Atoi(r.URL.Query().Get("pagetoken"))
+	end := start + pageSize
+	if end > len(places) {
+		end = len(places)
+	}
+	if start > end {
+		start = end
+	}
+
+	results := make([]map[string]any, 0, end-start)
+	for _, place := range places[start:end] {
+		results = append(results, placeJSON(place))
+	}
+
+	response := map[string]any{
+		"status":  "OK",
+		"results": results,
+	}
+	if end < len(places) {
+		response["next_page_token"] = strconv.Itoa(end)
+	}
+	writeJSON(w, response)
+}
+
+func (s *PlacesServer) details(w http.ResponseWriter, r *http.Request) {
+	if s.record(w, PlacesDetails) {
+		return
+	}
+
+	placeID := r.URL.Query().Get("placeid")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, place := range s.places {
+		if place.PlaceID == placeID {
+			result := placeJSON(place)
+			result["website"] = place.Website
+			result["formatted_phone_number"] = place.Phone
+			result["opening_hours"] = map[string]any{"weekday_text": place.Hours}
+			writeJSON(w, map[string]any{"status": "OK", "result": result})
+			return
+		}
+	}
+	writeJSON(w, map[string]any{"status": "NOT_FOUND"})
+}
+
+func (s *PlacesServer) geocode(w http.ResponseWriter, r *http.Request) {
+	if s.record(w, PlacesGeocode) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var match *Locality
+	if latlng := r.URL.Query().Get("latlng"); latlng != "" {
+		var lat, lng float64
+		fmt.Sscanf(latlng, "%f,%f", &lat, &lng)
+		closest := math.Inf(1)
+		for i, locality := range s.localities {
+			if d := math.Hypot(locality.Lat-lat, locality.Lng-lng); d < closest {
+				closest, match = d, &s.localities[i]
+			}
+		}
+	} else {
+		address := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("address")))
+		for i, locality := range s.localities {
+			for _, alias := range locality.Aliases {
+				if strings.ToLower(alias) == address {
+					match = &s.localities[i]
+				}
+			}
+		}
+	}
+
+	if match == nil {
+		writeJSON(w, map[string]any{"status": "ZERO_RESULTS", "results": []any{}})
+		return
+	}
+	writeJSON(w, map[string]any{"status": "OK", "results": []any{localityJSON(*match)}})
+}
+
+func localityJSON(locality Locality) map[string]any {
+	return map[string]any{
+		"place_id":          locality.PlaceID,
+		"formatted_address": fmt.Sprintf("%s, %s, %s", locality.City, locality.State, locality.Country),
+		"types":             []string{"locality", "political"},
+		"address_components": []map[string]any{
+			{"long_name": locality.City, "short_name": locality.City, "types": []string{"locality", "political"}},
+			{"long_name": locality.State, "short_name": locality.State, "types": []string{"administrative_area_level_1", "political"}},
+			{"long_name": locality.Country, "short_name": locality.Country, "types": []string{"country", "political"}},
+		},
+		"geometry": map[string]any{
+			"location": map[string]any{"lat": locality.Lat, "lng": locality.Lng},
+		},
+	}
+}
+
+func placeJSON(place Place) map[string]any {
+	return map[string]any{
+		"place_id":           place.PlaceID,
+		"name":               place.Name,
+		"formatted_address":  place.Address,
+		"rating":             place.Rating,
+		"user_ratings_total": place.ReviewCount,
+		"geometry": map[string]any{
+			"location": map[string]any{"lat": place.Lat, "lng": place.Lng},
+		},
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/SirClappington/bouncerate-backendv2/internal/errors"
+	"github.com/SirClappington/bouncerate-backendv2/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+func (s *Server) issueAPIKey(c *gin.Context) {
+	var request services.APIKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := request.Validate(); err != nil {
+		apiErr := errors.NewValidationError(err.Error())
+		apiErr.Details = gin.H{"operations": services.Operations()}
+		handleError(c, apiErr)
+		return
+	}
+
+	key, secret, err := s.services.APIKeys.Issue(c.Request.Context(), request)
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+
+	// The secret is only ever returned here
+	c.JSON(http.StatusCreated, gin.H{"apiKey": key, "secret": secret})
+}
+
+func (s *Server) listAPIKeys(c *gin.Context) {
+	keys, err := s.services.APIKeys.List(c.Request.Context())
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+
+	c.JSON(200, gin.H{"apiKeys": keys})
+}
+
+func (s *Server) getAPIKey(c *gin.Context) {
+	key, err := s.services.APIKeys.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+
+	c.JSON(200, key)
+}
+
+func (s *Server) revokeAPIKey(c *gin.Context) {
+	key, err := s.services.APIKeys.Revoke(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+
+	c.JSON(200, key)
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	goerrors "errors"
+	"strings"
+
+	"github.com/SirClappington/bouncerate-backendv2/internal/errors"
+	"github.com/SirClappington/bouncerate-backendv2/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// apiKeyHeader carries the API key of server-to-server clients.
+const apiKeyHeader = "X-API-Key"
+
+// apiKeyContextKey is the gin context key of the *services.APIKey a request
+// was authenticated with.
+const apiKeyContextKey = "apiKey"
+
+// authenticate verifies the API key in the X-API-Key header or else the
+// "Authorization: Bearer <ID token>" header, and attaches the caller's
+// account to the request context, where the services pick it up to scope
+// their data.
+func (s *Server) authenticate(c *gin.Context) {
+	if secret := c.GetHeader(apiKeyHeader); secret != "" && s.services.APIKeys != nil {
+		key, err := s.services.APIKeys.Authenticate(c.Request.Context(), secret)
+		if err != nil {
+			s.rejectCredentials(c, err)
+			return
+		}
+
+		c.Set(apiKeyContextKey, key)
+		c.Request = c.Request.WithContext(services.ContextWithAccount(c.Request.Context(), key.Account))
+		c.Next()
+		return
+	}
+
+	scheme, token, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+	token = strings.TrimSpace(token)
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		handleError(c, errors.NewUnauthorizedError("a bearer token is required"))
+		c.Abort()
+		return
+	}
+
+	account, err := s.services.Auth.Authenticate(c.Request.Context(), token)
+	if err != nil {
+		s.rejectCredentials(c, err)
+		return
+	}
+
+	c.Request = c.Request.WithContext(services.ContextWithAccount(c.Request.Context(), *account))
+	c.Next()
+}
+
+// rejectCredentials answers 401 for credentials that failed verification and
+// 500 when they couldn't be checked.
+func (s *Server) rejectCredentials(c *gin.Context, err error) {
+	s.logger.Printf("Rejected credentials: %v", err)
+	if goerrors.Is(err, services.ErrUnauthenticated) {
+		err = errors.NewUnauthorizedError("invalid, expired or revoked credentials")
+	}
+	handleError(c, err)
+	c.Abort()
+}
+
+// requireAdmin only lets through users with the admin claim.
+func (s *Server) requireAdmin(c *gin.Context) {
+	account, ok := services.AccountFromContext(c.Request.Context())
+	if _, byKey := c.Get(apiKeyContextKey); !ok || byKey || !account.Admin {
+		handleError(c, errors.NewForbiddenError("admin access required"))
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// meterQuotas counts the searches a request made with an API key runs
+// against the key's monthly quotas. Users signed in with Firebase aren't
+// metered.
+func (s *Server) meterQuotas(c *gin.Context) {
+	value, ok := c.Get(apiKeyContextKey)
+	if !ok {
+		c.Next()
+		return
+	}
+
+	id := value.(*services.APIKey).ID
+	keys := s.services.APIKeys
+	ctx := services.ContextWithQuota(c.Request.Context(), func(ctx context.Context, operation services.Operation) error {
+		return keys.Consume(ctx, id, operation)
+	})
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+}
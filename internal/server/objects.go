@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/SirClappington/bouncerate-backendv2/internal/errors"
+	"github.com/SirClappington/bouncerate-backendv2/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// maxObjectNameLength keeps object names well below the 1024 byte limit of
+// GCS.
+const maxObjectNameLength = 512
+
+// checkObjectName accepts clean relative names below one of the configured
+// ObjectPrefixes, so callers can't reach the search data or escape a local
+// store.
+func (s *Server) checkObjectName(name string) error {
+	if name == "" {
+		return errors.NewValidationError("object_name is required")
+	}
+	if len(name) > maxObjectNameLength || strings.ContainsAny(name, "\\\x00") {
+		return errors.NewValidationError("invalid object_name " + name)
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return errors.NewValidationError("invalid object_name " + name)
+		}
+	}
+
+	for _, prefix := range s.config.ObjectPrefixes {
+		if strings.HasPrefix(name, prefix) && len(name) > len(prefix) {
+			return nil
+		}
+	}
+	apiErr := errors.NewValidationError("object_name " + name + " is outside the allowed prefixes")
+	apiErr.Details = gin.H{"allowedPrefixes": s.config.ObjectPrefixes}
+	return apiErr
+}
+
+// upload streams the "file" part of a multipart form into the object named by
+// the object_name query parameter or a form field sent before the file,
+// stored in the caller's account (see services.FileObjectName). The body is
+// never buffered on disk.
+func (s *Server) upload(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, s.config.MaxUploadBytes)
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		handleError(c, errors.NewValidationError("multipart/form-data body required"))
+		return
+	}
+
+	objectName := c.Query("object_name")
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			handleError(c, errors.NewValidationError("file is required"))
+			return
+		}
+		if err != nil {
+			handleError(c, uploadError(err, s.config.MaxUploadBytes))
+			return
+		}
+
+		switch part.FormName() {
+		case "object_name":
+			value, err := io.ReadAll(io.LimitReader(part, maxObjectNameLength+1))
+			if err != nil {
+				handleError(c, uploadError(err, s.config.MaxUploadBytes))
+				return
+			}
+			objectName = string(value)
+		case "file":
+			if err := s.checkObjectName(objectName); err != nil {
+				handleError(c, err)
+				return
+			}
+
+			contentType := part.Header.Get("Content-Type")
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			ctx := c.Request.Context()
+			if err := s.services.Store.PutObject(ctx, services.FileObjectName(ctx, objectName), contentType, part); err != nil {
+				handleError(c, uploadError(err, s.config.MaxUploadBytes))
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"message": "File uploaded successfully", "object_name": objectName})
+			return
+		}
+	}
+}
+
+// uploadError reports a body over the size limit as a validation error.
+func uploadError(err error, limit int64) error {
+	var maxBytesErr *http.MaxBytesError
+	if goerrors.As(err, &maxBytesErr) {
+		return errors.NewValidationError(fmt.Sprintf("upload exceeds %d bytes", limit))
+	}
+	return err
+}
+
+// download streams an object back with its content type. Range and
+// conditional requests are handled by http.ServeContent.
+func (s *Server) download(c *gin.Context) {
+	objectName := c.Query("object_name")
+	if err := s.checkObjectName(objectName); err != nil {
+		handleError(c, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	storedName := services.FileObjectName(ctx, objectName)
+	attrs, err := s.services.Store.StatObject(ctx, storedName)
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+
+	content := &objectReadSeeker{ctx: ctx, store: s.services.Store, name: storedName, size: attrs.Size}
+	defer content.Close()
+
+	// Uploads are served as attachments so they can't run as pages of the API
+	c.Header("Content-Type", attrs.ContentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(objectName)}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	http.ServeContent(c.Writer, c.Request, path.Base(objectName), attrs.Updated, content)
+}
+
+// objectReadSeeker reads an object through ranged reads, opening a new reader
+// whenever it is moved, so http.ServeContent can serve byte ranges without
+// the object being buffered.
+type objectReadSeeker struct {
+	ctx    context.Context
+	store  services.Store
+	name   string
+	size   int64
+	offset int64
+	rc     io.ReadCloser
+}
+
+func (r *objectReadSeeker) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.rc == nil {
+		rc, err := r.store.OpenObject(r.ctx, r.name, r.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.rc = rc
+	}
+	n, err := r.rc.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *objectReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position %d", offset)
+	}
+
+	if offset != r.offset {
+		r.Close()
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+func (r *objectReadSeeker) Close() error {
+	if r.rc == nil {
+		return nil
+	}
+	err := r.rc.Close()
+	r.rc = nil
+	return err
+}
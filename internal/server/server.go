@@ -0,0 +1,501 @@
+// Package server exposes the Bounce Rate services over HTTP.
+package server
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/SirClappington/bouncerate-backendv2/internal/errors"
+	"github.com/SirClappington/bouncerate-backendv2/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// CompetitorSearcher discovers and scrapes the competitors in a location.
+type CompetitorSearcher interface {
+	SearchCompetitors(ctx context.Context, area services.SearchArea, freshness services.Freshness) (*services.CompetitorSearchResult, error)
+}
+
+// SearchJobs runs competitor searches in the background.
+type SearchJobs interface {
+	Submit(ctx context.Context, area services.SearchArea, filter services.CompetitorFilter, freshness services.Freshness) (*services.SearchJob, error)
+	Get(ctx context.Context, id string) (*services.SearchJob, bool)
+}
+
+// Analyzer answers pricing questions from stored competitor data.
+type Analyzer interface {
+	AnalyzePurchase(ctx context.Context, location, category string, purchasePrice float64, costs services.PurchaseCosts, filter services.CompetitorFilter) (*services.PurchaseAnalysis, error)
+	PriceDistribution(ctx context.Context, location, category string, filter services.CompetitorFilter) (*services.PriceDistribution, error)
+}
+
+// Authenticator verifies the bearer token of a request and returns the
+// account it was issued to.
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (*services.Account, error)
+}
+
+// APIKeys authenticates server-to-server clients and meters their quotas.
+type APIKeys interface {
+	Authenticate(ctx context.Context, secret string) (*services.APIKey, error)
+	Consume(ctx context.Context, id string, operation services.Operation) error
+	CheckQuota(ctx context.Context, id string, operation services.Operation) error
+	Issue(ctx context.Context, request services.APIKeyRequest) (*services.APIKey, string, error)
+	Revoke(ctx context.Context, id string) (*services.APIKey, error)
+	Get(ctx context.Context, id string) (*services.APIKey, error)
+	List(ctx context.Context) ([]services.APIKey, error)
+}
+
+// RateLimitReporter reports the usage of the upstream rate limits.
+type RateLimitReporter interface {
+	Stats() map[string]services.RateLimiterStats
+}
+
+// Config holds the HTTP settings of the server.
+type Config struct {
+	// Port is the port Run listens on. Defaults to 8080.
+	Port string
+	// ShutdownTimeout bounds how long Run waits for in-flight requests on
+	// shutdown. Defaults to 30s.
+	ShutdownTimeout time.Duration
+	// ObjectPrefixes are the object name prefixes /upload and /download may
+	// touch. Defaults to "uploads/".
+	ObjectPrefixes []string
+	// MaxUploadBytes bounds the body of /upload. Defaults to 32 MiB.
+	MaxUploadBytes int64
+}
+
+// Services are the dependencies the routes are served from.
+type Services struct {
+	Store       services.Store
+	Competitors CompetitorSearcher
+	SearchJobs  SearchJobs
+	Analysis    Analyzer
+	RateLimits  RateLimitReporter
+	// Auth protects every route but "/" and "/categories". When nil the API
+	// is public and all data is unscoped.
+	Auth Authenticator
+	// APIKeys, if set along with Auth, also accepts API keys and serves the
+	// admin routes managing them.
+	APIKeys APIKeys
+}
+
+type Server struct {
+	config   Config
+	services Services
+	logger   *log.Logger
+}
+
+func New(config Config, svc Services, logger *log.Logger) *Server {
+	if config.Port == "" {
+		config.Port = "8080" // Default port if not specified
+	}
+	if config.ShutdownTimeout <= 0 {
+		config.ShutdownTimeout = 30 * time.Second
+	}
+	if len(config.ObjectPrefixes) == 0 {
+		config.ObjectPrefixes = []string{"uploads/"}
+	}
+	if config.MaxUploadBytes <= 0 {
+		config.MaxUploadBytes = 32 << 20
+	}
+
+	return &Server{
+		config:   config,
+		services: svc,
+		logger:   logger,
+	}
+}
+
+// Run serves Router on the configured port until ctx is cancelled, then
+// waits up to ShutdownTimeout for in-flight requests to finish.
+func (s *Server) Run(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:    ":" + s.config.Port,
+		Handler: s.Router(),
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.logger.Printf("Shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("error shutting down server: %v", err)
+	}
+	return nil
+}
+
+// Router registers every route.
+func (s *Server) Router() *gin.Engine {
+	r := gin.Default()
+
+	r.GET("/", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"message": "Welcome to Bounce Rate API!"})
+	})
+
+	r.GET("/categories", func(c *gin.Context) {
+		c.JSON(200, gin.H{"categories": services.Categories()})
+	})
+
+	api := r.Group("/")
+	if s.services.Auth != nil {
+		api.Use(s.authenticate)
+	}
+	api.POST("/upload", s.upload)
+	api.GET("/download", s.download)
+	api.POST("/analyze-purchase", s.analyzePurchase)
+	api.GET("/analysis/prices", s.priceDistribution)
+	api.GET("/search", s.meterQuotas, s.search)
+	api.POST("/searches", s.meterQuotas, s.submitSearch)
+	api.GET("/searches/:id", s.getSearch)
+	api.GET("/metrics/rate-limits", s.rateLimitStats)
+
+	if s.services.Auth != nil && s.services.APIKeys != nil {
+		admin := api.Group("/admin", s.requireAdmin)
+		admin.POST("/api-keys", s.issueAPIKey)
+		admin.GET("/api-keys", s.listAPIKeys)
+		admin.GET("/api-keys/:id", s.getAPIKey)
+		admin.DELETE("/api-keys/:id", s.revokeAPIKey)
+	}
+
+	return r
+}
+
+func (s *Server) analyzePurchase(c *gin.Context) {
+	var request struct {
+		ProductType   string  `json:"productType" binding:"required"`
+		PurchasePrice float64 `json:"purchasePrice" binding:"required"`
+		Location      string  `json:"location" binding:"required"`
+		services.PurchaseCosts
+		distanceFilterRequest
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	category, ok := services.ParseCategory(request.ProductType)
+	if !ok {
+		apiErr := errors.NewValidationError("unknown productType " + request.ProductType)
+		apiErr.Details = gin.H{"allowed": services.Categories()}
+		handleError(c, apiErr)
+		return
+	}
+
+	if request.PurchasePrice <= 0 {
+		handleError(c, errors.NewValidationError("purchasePrice must be positive"))
+		return
+	}
+
+	if err := request.PurchaseCosts.Validate(); err != nil {
+		handleError(c, errors.NewValidationError(err.Error()))
+		return
+	}
+
+	filter, err := request.filter(nil)
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+
+	analysis, err := s.services.Analysis.AnalyzePurchase(c.Request.Context(), request.Location, category, request.PurchasePrice, request.PurchaseCosts, filter)
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+
+	// breakEvenPoint predates the full analysis and is kept as an int for
+	// existing clients. It is the same net figure as
+	// analysis.breakEvenRentals, or 0 when the unit never breaks even.
+	breakEvenPoint := 0
+	if analysis.BreakEvenRentals != nil {
+		breakEvenPoint = *analysis.BreakEvenRentals
+	}
+	c.JSON(200, gin.H{
+		"message":        "Successfully analyzed purchase",
+		"averagePrice":   analysis.AveragePrice,
+		"breakEvenPoint": breakEvenPoint,
+		"analysis":       analysis,
+	})
+}
+
+func (s *Server) priceDistribution(c *gin.Context) {
+	location := c.Query("location")
+	if location == "" {
+		c.JSON(400, gin.H{"error": "location query parameter is required"})
+		return
+	}
+
+	category, ok := services.ParseCategory(c.Query("category"))
+	if !ok {
+		apiErr := errors.NewValidationError("unknown category " + c.Query("category"))
+		apiErr.Details = gin.H{"allowed": services.Categories()}
+		handleError(c, apiErr)
+		return
+	}
+
+	var request distanceFilterRequest
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	filter, err := request.filter(nil)
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+
+	distribution, err := s.services.Analysis.PriceDistribution(c.Request.Context(), location, category, filter)
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+
+	c.JSON(200, distribution)
+}
+
+// searchAreaRequest is the area of a search as given in the query string of
+// GET /search or the body of POST /searches.
+type searchAreaRequest struct {
+	Location string   `form:"location" json:"location"`
+	Lat      *float64 `form:"lat" json:"lat"`
+	Lng      *float64 `form:"lng" json:"lng"`
+	Radius   uint     `form:"radius" json:"radius"`
+	distanceFilterRequest
+	freshnessRequest
+}
+
+func (r searchAreaRequest) area() (services.SearchArea, error) {
+	area := services.SearchArea{
+		Location:     r.Location,
+		RadiusMeters: r.Radius,
+	}
+	if (r.Lat == nil) != (r.Lng == nil) {
+		return area, errors.NewValidationError("lat and lng must be given together")
+	}
+	if r.Lat != nil {
+		area.Center = &services.LatLng{Lat: *r.Lat, Lng: *r.Lng}
+	}
+	if err := area.Validate(); err != nil {
+		return area, errors.NewValidationError(err.Error())
+	}
+	return area, nil
+}
+
+// distanceFilterRequest limits competitors to those within MaxDistance
+// meters of the origin, which is the caller's own business.
+type distanceFilterRequest struct {
+	OriginLat   *float64 `form:"originLat" json:"originLat"`
+	OriginLng   *float64 `form:"originLng" json:"originLng"`
+	MaxDistance float64  `form:"maxDistance" json:"maxDistance"`
+}
+
+// filter builds the competitor filter. When no origin is given it falls back
+// to defaultOrigin, which may be nil.
+func (r distanceFilterRequest) filter(defaultOrigin *services.LatLng) (services.CompetitorFilter, error) {
+	filter := services.CompetitorFilter{
+		Origin:            defaultOrigin,
+		MaxDistanceMeters: r.MaxDistance,
+	}
+	if (r.OriginLat == nil) != (r.OriginLng == nil) {
+		return filter, errors.NewValidationError("originLat and originLng must be given together")
+	}
+	if r.OriginLat != nil {
+		filter.Origin = &services.LatLng{Lat: *r.OriginLat, Lng: *r.OriginLng}
+	}
+	if err := filter.Validate(); err != nil {
+		return filter, errors.NewValidationError(err.Error())
+	}
+	return filter, nil
+}
+
+// freshnessRequest lets a search be answered from stored results up to
+// MaxAge old, given as a duration such as "6h" or in seconds, or forces a new
+// search with Refresh.
+type freshnessRequest struct {
+	MaxAge  string `form:"max_age" json:"maxAge"`
+	Refresh bool   `form:"refresh" json:"refresh"`
+}
+
+func (r freshnessRequest) freshness() (services.Freshness, error) {
+	freshness := services.Freshness{Refresh: r.Refresh}
+	if r.MaxAge == "" {
+		return freshness, nil
+	}
+
+	maxAge, err := time.ParseDuration(r.MaxAge)
+	if err != nil {
+		seconds, convErr := strconv.ParseUint(r.MaxAge, 10, 32)
+		if convErr != nil {
+			return freshness, errors.NewValidationError("max_age must be a duration such as 6h or a number of seconds")
+		}
+		maxAge = time.Duration(seconds) * time.Second
+	}
+	if maxAge < 0 {
+		return freshness, errors.NewValidationError("max_age cannot be negative")
+	}
+	// No stored result is young enough for max_age=0
+	freshness.MaxAge = maxAge
+	freshness.Refresh = freshness.Refresh || maxAge == 0
+	return freshness, nil
+}
+
+func (s *Server) search(c *gin.Context) {
+	var request searchAreaRequest
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	area, err := request.area()
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+
+	filter, err := request.filter(area.Center)
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+
+	freshness, err := request.freshness()
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+
+	result, err := s.services.Competitors.SearchCompetitors(c.Request.Context(), area, freshness)
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+
+	c.JSON(200, result.Filter(filter))
+}
+
+func (s *Server) submitSearch(c *gin.Context) {
+	var request searchAreaRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	area, err := request.area()
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+
+	filter, err := request.filter(area.Center)
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+
+	freshness, err := request.freshness()
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+
+	// The job only consumes the quota once it runs, so a key that has used
+	// it up is refused here rather than handed a job that fails.
+	if value, ok := c.Get(apiKeyContextKey); ok {
+		id := value.(*services.APIKey).ID
+		if err := s.services.APIKeys.CheckQuota(c.Request.Context(), id, services.OperationSearch); err != nil {
+			handleError(c, err)
+			return
+		}
+	}
+
+	job, err := s.services.SearchJobs.Submit(c.Request.Context(), area, filter, freshness)
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+
+	c.Header("Location", "/searches/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+func (s *Server) getSearch(c *gin.Context) {
+	job, ok := s.services.SearchJobs.Get(c.Request.Context(), c.Param("id"))
+	if !ok {
+		handleError(c, errors.NewNotFoundError("search job not found"))
+		return
+	}
+
+	c.JSON(200, job)
+}
+
+func (s *Server) rateLimitStats(c *gin.Context) {
+	if s.services.RateLimits == nil {
+		c.JSON(200, gin.H{"limits": gin.H{}})
+		return
+	}
+
+	c.JSON(200, gin.H{"limits": s.services.RateLimits.Stats()})
+}
+
+// queueFullRetryAfter is the Retry-After, in seconds, of searches refused
+// because the queue is full.
+const queueFullRetryAfter = 30
+
+func handleError(c *gin.Context, err error) {
+	switch {
+	case goerrors.Is(err, services.ErrLocationNotFound), goerrors.Is(err, services.ErrNoProducts):
+		err = errors.NewNotFoundError(err.Error())
+	case goerrors.Is(err, services.ErrObjectNotFound):
+		// The error names the object, which reveals the bucket layout and
+		// account IDs, so it only goes to the request log
+		c.Error(err)
+		err = errors.NewNotFoundError("not found")
+	case goerrors.Is(err, services.ErrQueueFull):
+		c.Header("Retry-After", strconv.Itoa(queueFullRetryAfter))
+		err = errors.NewUnavailableError("too many searches are queued, try again later")
+	}
+	var quotaErr *services.QuotaExceededError
+	if goerrors.As(err, &quotaErr) {
+		retryAfter := time.Until(quotaErr.ResetsAt).Seconds()
+		c.Header("Retry-After", strconv.Itoa(int(retryAfter)+1))
+		err = errors.NewQuotaExceededError(quotaErr.Error(), quotaErr)
+	}
+
+	if apiErr, ok := err.(*errors.APIError); ok {
+		switch apiErr.Type {
+		case errors.ErrorTypeValidation:
+			c.JSON(http.StatusBadRequest, apiErr)
+		case errors.ErrorTypeNotFound:
+			c.JSON(http.StatusNotFound, apiErr)
+		case errors.ErrorTypeExternal, errors.ErrorTypeUnavailable:
+			c.JSON(http.StatusServiceUnavailable, apiErr)
+		case errors.ErrorTypeUnauthorized:
+			c.JSON(http.StatusUnauthorized, apiErr)
+		case errors.ErrorTypeForbidden:
+			c.JSON(http.StatusForbidden, apiErr)
+		case errors.ErrorTypeQuotaExceeded:
+			c.JSON(http.StatusTooManyRequests, apiErr)
+		default:
+			c.JSON(http.StatusInternalServerError, apiErr)
+		}
+		return
+	}
+
+	// Handle unknown errors
+	c.JSON(http.StatusInternalServerError, errors.NewInternalError(err))
+}
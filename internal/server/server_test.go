@@ -0,0 +1,767 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SirClappington/bouncerate-backendv2/internal/errors"
+	"github.com/SirClappington/bouncerate-backendv2/internal/fakes"
+	"github.com/SirClappington/bouncerate-backendv2/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// newTestRouter serves newTestServices without authentication.
+func newTestRouter(t *testing.T, places *fakes.PlacesServer, firecrawl *fakes.FirecrawlServer) *gin.Engine {
+	t.Helper()
+	return New(Config{}, newTestServices(t, places, firecrawl), log.New(io.Discard, "", 0)).Router()
+}
+
+// newTestServices wires the real services to fake Places and Firecrawl
+// servers and an in-memory store.
+func newTestServices(t *testing.T, places *fakes.PlacesServer, firecrawl *fakes.FirecrawlServer) Services {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	logger := log.New(io.Discard, "", 0)
+
+	store := services.NewMemoryStore(logger)
+	// Generous budgets keep the suite fast while still going through Wait
+	rateLimits := services.NewRateLimits(services.RateLimitConfig{
+		Firecrawl: services.RateBudget{Requests: 100, Per: time.Second},
+		Places:    services.RateBudget{Requests: 100, Per: time.Second},
+		Domain:    services.RateBudget{Requests: 100, Per: time.Second},
+	})
+	retry := services.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+
+	firecrawlClient, err := services.NewFirecrawlClient("test-firecrawl-key", firecrawl.BaseURL(), 0, rateLimits, retry)
+	if err != nil {
+		t.Fatalf("NewFirecrawlClient: %v", err)
+	}
+	placesClient, err := services.NewPlacesClient("test-places-key", places.URL, rateLimits.Places, retry)
+	if err != nil {
+		t.Fatalf("NewPlacesClient: %v", err)
+	}
+
+	locations := services.NewLocationResolver(placesClient, store, logger)
+	competitors := services.NewCompetitorService(firecrawlClient, placesClient, locations, store, services.CacheConfig{}, logger)
+	searchJobs := services.NewSearchJobService(competitors, 1, 10, logger)
+	t.Cleanup(searchJobs.Close)
+
+	return Services{
+		Store:       store,
+		Competitors: competitors,
+		SearchJobs:  searchJobs,
+		Analysis:    services.NewAnalysisService(store, locations, logger),
+		RateLimits:  rateLimits,
+	}
+}
+
+// newFixtures scripts a market with one competitor found through map, one
+// found only through a crawl and one without a website.
+func newFixtures(t *testing.T) (*fakes.PlacesServer, *fakes.FirecrawlServer) {
+	places := fakes.NewPlacesServer()
+	t.Cleanup(places.Close)
+	firecrawl := fakes.NewFirecrawlServer()
+	t.Cleanup(firecrawl.Close)
+
+	places.AddLocality(fakes.Locality{PlaceID: "austin", City: "Austin", State: "TX", Country: "US", Lat: 30.2672, Lng: -97.7431,
+		Aliases: []string{"Austin", "Austin, TX", "Austin Texas"}})
+
+	// Jumpin Jacks is in downtown Austin, Bounce Bros about 41km north
+	places.AddPlace(fakes.Place{PlaceID: "p1", Name: "Jumpin Jacks", Address: "100 Congress Ave, Austin, TX", Lat: 30.2672, Lng: -97.7431,
+		Website: "https://jumpinjacks.example", Phone: "(512) 555-0100", Rating: 4.5, ReviewCount: 120})
+	places.AddPlace(fakes.Place{PlaceID: "p2", Name: "Bounce Bros", Address: "Georgetown, TX", Lat: 30.6333, Lng: -97.6780,
+		Website: "https://bouncebros.example"})
+	places.AddPlace(fakes.Place{PlaceID: "p3", Name: "No Site Rentals"})
+
+	firecrawl.AddSite("https://jumpinjacks.example", fakes.Site{
+		Links: []string{"https://jumpinjacks.example/about", "https://jumpinjacks.example/rentals"},
+	})
+	firecrawl.AddPage("https://jumpinjacks.example/rentals",
+		services.ProductSchema{Name: "Castle Bounce House", Price: "$150", Category: "bounce-house"},
+		services.ProductSchema{Name: "Rainbow Moonwalk", Price: "$25/hr"},
+		services.ProductSchema{Name: "Tiki Water Slide", Price: "$300 - $350", Category: "water-slide"},
+	)
+
+	firecrawl.AddSite("https://bouncebros.example", fakes.Site{
+		CrawlLinks: []string{"https://bouncebros.example/contact", "https://bouncebros.example/inventory"},
+	})
+	firecrawl.AddPage("https://bouncebros.example/inventory",
+		services.ProductSchema{Name: "Princess Bouncer", Price: "From $250"},
+	)
+
+	return places, firecrawl
+}
+
+func TestSearchAndAnalyzePurchase(t *testing.T) {
+	places, firecrawl := newFixtures(t)
+	router := newTestRouter(t, places, firecrawl)
+
+	w := serve(router, "GET", "/search?location=Austin", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /search = %d: %s", w.Code, w.Body)
+	}
+	var result services.CompetitorSearchResult
+	decode(t, w, &result)
+	if result.TotalFound != 2 {
+		t.Fatalf("TotalFound = %d, want 2: %+v", result.TotalFound, result)
+	}
+	for _, competitor := range result.Competitors {
+		if competitor.Name == "Jumpin Jacks" && (competitor.Phone != "(512) 555-0100" || competitor.ReviewCount != 120) {
+			t.Errorf("competitor = %+v, want Places details", competitor)
+		}
+	}
+	if firecrawl.Requests(fakes.FirecrawlCrawl) != 1 {
+		t.Errorf("crawl requests = %d, want 1 for the site map found nothing on", firecrawl.Requests(fakes.FirecrawlCrawl))
+	}
+
+	body := map[string]any{"productType": "Bounce House", "purchasePrice": 2000, "location": "Austin", "cleaningPerRental": 40}
+	w = serve(router, "POST", "/analyze-purchase", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /analyze-purchase = %d: %s", w.Code, w.Body)
+	}
+	var analysis struct {
+		AveragePrice   float64 `json:"averagePrice"`
+		BreakEvenPoint int     `json:"breakEvenPoint"`
+		Analysis       struct {
+			BreakEvenRentals *int `json:"breakEvenRentals"`
+		} `json:"analysis"`
+	}
+	decode(t, w, &analysis)
+	// $150/day, $25/hr * 8 and $250/day
+	if analysis.AveragePrice != 200 {
+		t.Errorf("averagePrice = %v, want 200", analysis.AveragePrice)
+	}
+	// 2000 at $160 net of cleaning per rental
+	if got, want := analysis.BreakEvenPoint, analysis.Analysis.BreakEvenRentals; got != 13 || want == nil || *want != 13 {
+		t.Errorf("breakEvenPoint = %d, breakEvenRentals = %v, want both 13", got, want)
+	}
+}
+
+func TestSearchAndAnalyzeWithinDistance(t *testing.T) {
+	places, firecrawl := newFixtures(t)
+	router := newTestRouter(t, places, firecrawl)
+
+	w := serve(router, "GET", "/search?location=Austin&originLat=30.2700&originLng=-97.7400&maxDistance=20000", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /search = %d: %s", w.Code, w.Body)
+	}
+	var result services.CompetitorSearchResult
+	decode(t, w, &result)
+	if result.TotalFound != 1 || len(result.Competitors) != 1 {
+		t.Fatalf("TotalFound = %d, want only Jumpin Jacks: %+v", result.TotalFound, result)
+	}
+	competitor := result.Competitors[0]
+	if competitor.PlaceID != "p1" || competitor.Address != "100 Congress Ave, Austin, TX" || competitor.Location == nil {
+		t.Errorf("competitor = %+v, want Place ID, address and location", competitor)
+	}
+	if competitor.DistanceMeters == nil || *competitor.DistanceMeters < 300 || *competitor.DistanceMeters > 600 {
+		t.Errorf("DistanceMeters = %v, want about 450", competitor.DistanceMeters)
+	}
+
+	body := map[string]any{"productType": "Bounce House", "purchasePrice": 2000, "location": "Austin",
+		"originLat": 30.27, "originLng": -97.74, "maxDistance": 20000}
+	w = serve(router, "POST", "/analyze-purchase", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /analyze-purchase = %d: %s", w.Code, w.Body)
+	}
+	var analysis struct {
+		AveragePrice float64 `json:"averagePrice"`
+	}
+	decode(t, w, &analysis)
+	// $150/day and $25/hr * 8 from Jumpin Jacks only
+	if analysis.AveragePrice != 175 {
+		t.Errorf("averagePrice = %v, want 175", analysis.AveragePrice)
+	}
+
+	// The stored snapshot keeps every competitor, so a wider radius sees both
+	w = serve(router, "GET", "/analysis/prices?location=Austin&category=bounce-house&originLat=30.27&originLng=-97.74&maxDistance=50000", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /analysis/prices = %d: %s", w.Code, w.Body)
+	}
+	var distribution services.PriceDistribution
+	decode(t, w, &distribution)
+	if len(distribution.Competitors) != 2 {
+		t.Errorf("got %d competitors within 50km, want 2", len(distribution.Competitors))
+	}
+	for _, stats := range distribution.Competitors {
+		if stats.DistanceMeters == nil {
+			t.Errorf("competitor %s has no distance", stats.Name)
+		}
+	}
+
+	w = serve(router, "GET", "/analysis/prices?location=Austin&category=bounce-house&maxDistance=20000", nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("maxDistance without origin = %d, want 400", w.Code)
+	}
+}
+
+func TestLocationAliasesShareData(t *testing.T) {
+	places, firecrawl := newFixtures(t)
+	router := newTestRouter(t, places, firecrawl)
+
+	w := serve(router, "GET", "/search?location=Austin,%20TX", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /search = %d: %s", w.Code, w.Body)
+	}
+	var result services.CompetitorSearchResult
+	decode(t, w, &result)
+	if result.Canonical == nil || result.Canonical.Key != "austin-tx-us" {
+		t.Fatalf("canonical = %+v, want austin-tx-us", result.Canonical)
+	}
+
+	w = serve(router, "GET", "/analysis/prices?location=austin%20texas&category=bounce-house", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /analysis/prices for an alias = %d: %s", w.Code, w.Body)
+	}
+	var distribution services.PriceDistribution
+	decode(t, w, &distribution)
+	if distribution.Location != "Austin, TX, US" || distribution.Overall.Count != 3 {
+		t.Errorf("distribution = %+v, want the data stored for Austin, TX", distribution)
+	}
+
+	w = serve(router, "GET", "/analysis/prices?location=Atlantis&category=bounce-house", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown location = %d, want 404", w.Code)
+	}
+
+	w = serve(router, "GET", "/analysis/prices?location=Austin&category=dunk-tank", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET /analysis/prices for a category nobody rents = %d, want 404", w.Code)
+	}
+	body := map[string]any{"productType": "dunk-tank", "purchasePrice": 2000, "location": "Austin"}
+	if w := serve(router, "POST", "/analyze-purchase", body); w.Code != http.StatusNotFound {
+		t.Errorf("POST /analyze-purchase for a category nobody rents = %d, want 404", w.Code)
+	}
+}
+
+func TestSearchCache(t *testing.T) {
+	places, firecrawl := newFixtures(t)
+	router := newTestRouter(t, places, firecrawl)
+
+	w := serve(router, "GET", "/search?location=Austin", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /search = %d: %s", w.Code, w.Body)
+	}
+	var first services.CompetitorSearchResult
+	decode(t, w, &first)
+	if first.Cached || first.CollectedAt.IsZero() {
+		t.Errorf("first search cached = %v, collectedAt = %v", first.Cached, first.CollectedAt)
+	}
+	scrapes := firecrawl.Requests(fakes.FirecrawlScrape)
+
+	// An alias of the same city is answered from the stored result
+	w = serve(router, "GET", "/search?location=Austin%20Texas", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /search = %d: %s", w.Code, w.Body)
+	}
+	var cached services.CompetitorSearchResult
+	decode(t, w, &cached)
+	if !cached.Cached || cached.TotalFound != first.TotalFound || cached.CollectedAt.After(time.Now()) {
+		t.Errorf("second search = %+v, want the first result from the cache", cached)
+	}
+	if n := firecrawl.Requests(fakes.FirecrawlScrape); n != scrapes {
+		t.Errorf("scrape requests = %d, want %d", n, scrapes)
+	}
+
+	// A coordinate search covers a different area
+	w = serve(router, "GET", "/search?lat=30.2672&lng=-97.7431&radius=10000", nil)
+	var circle services.CompetitorSearchResult
+	decode(t, w, &circle)
+	if circle.Cached {
+		t.Error("coordinate search answered from the text search")
+	}
+
+	tests := []struct {
+		query      string
+		wantCached bool
+	}{
+		{"refresh=true", false},
+		{"max_age=0", false},
+		{"max_age=1h", true},
+		{"max_age=3600", true},
+	}
+	for _, tt := range tests {
+		w := serve(router, "GET", "/search?location=Austin&"+tt.query, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /search?%s = %d: %s", tt.query, w.Code, w.Body)
+		}
+		var result services.CompetitorSearchResult
+		decode(t, w, &result)
+		if result.Cached != tt.wantCached {
+			t.Errorf("GET /search?%s cached = %v, want %v", tt.query, result.Cached, tt.wantCached)
+		}
+	}
+
+	if w := serve(router, "GET", "/search?location=Austin&max_age=soon", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid max_age = %d, want 400", w.Code)
+	}
+}
+
+func TestOverlappingSearchesShareScrapes(t *testing.T) {
+	places, firecrawl := newFixtures(t)
+	svc := newTestServices(t, places, firecrawl)
+	svc.Auth = stubAuth{"alice-token": {UserID: "alice"}, "bob-token": {UserID: "bob"}}
+	router := New(Config{}, svc, log.New(io.Discard, "", 0)).Router()
+
+	if w := serveAs(router, "alice-token", "GET", "/search?location=Austin", nil); w.Code != http.StatusOK {
+		t.Fatalf("GET /search = %d: %s", w.Code, w.Body)
+	}
+	maps, crawls, scrapes := firecrawl.Requests(fakes.FirecrawlMap), firecrawl.Requests(fakes.FirecrawlCrawl), firecrawl.Requests(fakes.FirecrawlScrape)
+	if n := firecrawl.Extractions(); n != 2 {
+		t.Fatalf("extractions = %d, want one per product page", n)
+	}
+
+	// Another area, a refresh and another account all find the same
+	// competitors, whose websites are answered from the site cache
+	for _, search := range []struct{ token, query string }{
+		{"alice-token", "lat=30.2672&lng=-97.7431&radius=50000"},
+		{"alice-token", "location=Austin&refresh=true"},
+		{"bob-token", "location=Austin"},
+	} {
+		w := serveAs(router, search.token, "GET", "/search?"+search.query, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /search?%s = %d: %s", search.query, w.Code, w.Body)
+		}
+		var result services.CompetitorSearchResult
+		decode(t, w, &result)
+		if result.Cached || result.TotalFound != 2 {
+			t.Errorf("GET /search?%s cached = %v, totalFound = %d, want a new search finding 2", search.query, result.Cached, result.TotalFound)
+		}
+	}
+	if n := firecrawl.Requests(fakes.FirecrawlMap); n != maps {
+		t.Errorf("map requests = %d, want %d", n, maps)
+	}
+	if n := firecrawl.Requests(fakes.FirecrawlCrawl); n != crawls {
+		t.Errorf("crawl requests = %d, want %d", n, crawls)
+	}
+	if n := firecrawl.Requests(fakes.FirecrawlScrape); n != scrapes {
+		t.Errorf("scrape requests = %d, want %d", n, scrapes)
+	}
+}
+
+func TestAnalyzePurchaseValidation(t *testing.T) {
+	places, firecrawl := newFixtures(t)
+	router := newTestRouter(t, places, firecrawl)
+
+	body := map[string]any{"productType": "spaceship", "purchasePrice": 2000, "location": "Austin"}
+	if w := serve(router, "POST", "/analyze-purchase", body); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown productType = %d, want 400", w.Code)
+	}
+
+	body = map[string]any{"productType": "combo", "purchasePrice": -2000, "location": "Austin"}
+	if w := serve(router, "POST", "/analyze-purchase", body); w.Code != http.StatusBadRequest {
+		t.Errorf("negative purchasePrice = %d, want 400", w.Code)
+	}
+
+	body = map[string]any{"productType": "combo", "purchasePrice": 2000, "location": "Austin", "seasonality": []float64{1}}
+	if w := serve(router, "POST", "/analyze-purchase", body); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid seasonality = %d, want 400", w.Code)
+	}
+}
+
+func TestSearchPlacesFailure(t *testing.T) {
+	places, firecrawl := newFixtures(t)
+	router := newTestRouter(t, places, firecrawl)
+	places.FailNext(fakes.PlacesTextSearch, 3, http.StatusInternalServerError)
+
+	if w := serve(router, "GET", "/search?location=Austin", nil); w.Code < 500 {
+		t.Errorf("GET /search with Places down = %d, want 5xx", w.Code)
+	}
+	if n := firecrawl.Requests(fakes.FirecrawlMap); n != 0 {
+		t.Errorf("map requests = %d, want none when discovery fails", n)
+	}
+}
+
+func TestSearchSkipsFailedScrapes(t *testing.T) {
+	places, firecrawl := newFixtures(t)
+	router := newTestRouter(t, places, firecrawl)
+	// Each of the two pages fails every attempt
+	firecrawl.FailNext(fakes.FirecrawlScrape, 6, http.StatusBadGateway)
+
+	w := serve(router, "GET", "/search?location=Austin", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /search = %d: %s", w.Code, w.Body)
+	}
+	var result services.CompetitorSearchResult
+	decode(t, w, &result)
+	if result.TotalFound != 0 {
+		t.Errorf("TotalFound = %d, want 0 when every scrape fails", result.TotalFound)
+	}
+
+	// The empty result isn't served from the cache once scrapes recover
+	w = serve(router, "GET", "/search?location=Austin", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /search = %d: %s", w.Code, w.Body)
+	}
+	decode(t, w, &result)
+	if result.Cached || result.TotalFound != 2 {
+		t.Errorf("second search cached = %v, totalFound = %d, want a new search finding 2", result.Cached, result.TotalFound)
+	}
+}
+
+func TestSearchRetriesTransientFailures(t *testing.T) {
+	places, firecrawl := newFixtures(t)
+	router := newTestRouter(t, places, firecrawl)
+	places.FailNext(fakes.PlacesTextSearch, 1, http.StatusServiceUnavailable)
+	places.FailNext(fakes.PlacesDetails, 2, http.StatusTooManyRequests)
+	firecrawl.FailNext(fakes.FirecrawlScrape, 1, http.StatusBadGateway)
+
+	w := serve(router, "GET", "/search?location=Austin", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /search = %d: %s", w.Code, w.Body)
+	}
+	var result services.CompetitorSearchResult
+	decode(t, w, &result)
+	if result.TotalFound != 2 {
+		t.Errorf("TotalFound = %d, want 2 once retries succeed", result.TotalFound)
+	}
+	if n := places.Requests(fakes.PlacesTextSearch); n != 5 {
+		t.Errorf("text search requests = %d, want one per phrasing plus the retry", n)
+	}
+}
+
+func TestUploadDownload(t *testing.T) {
+	places, firecrawl := newFixtures(t)
+	router := newTestRouter(t, places, firecrawl)
+
+	w := upload(router, "/upload", map[string]string{"object_name": "uploads/price-list.csv"}, "text/csv", "a,b\n1,2\n")
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /upload = %d: %s", w.Code, w.Body)
+	}
+
+	w = serve(router, "GET", "/download?object_name=uploads/price-list.csv", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "a,b\n1,2\n" {
+		t.Fatalf("GET /download = %d: %q", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/csv" {
+		t.Errorf("Content-Type = %q, want text/csv", got)
+	}
+	if got := w.Header().Get("Content-Disposition"); got != "attachment; filename=price-list.csv" {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+
+	req := httptest.NewRequest("GET", "/download?object_name=uploads/price-list.csv", nil)
+	req.Header.Set("Range", "bytes=4-6")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "1,2" {
+		t.Errorf("ranged GET /download = %d: %q, want 206 \"1,2\"", w.Code, w.Body)
+	}
+
+	w = serve(router, "GET", "/download?object_name=uploads/missing.csv", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET /download of a missing object = %d, want 404", w.Code)
+	}
+	if strings.Contains(w.Body.String(), services.LayoutVersion+"/") {
+		t.Errorf("404 body %s reveals the object name", w.Body)
+	}
+}
+
+func TestUploadDownloadRejectsObjectNames(t *testing.T) {
+	places, firecrawl := newFixtures(t)
+	router := newTestRouter(t, places, firecrawl)
+
+	for _, name := range []string{"", "v2/locations/austin-tx-us/location.json", "uploads/../v2/aliases/x.json", "/uploads/x", "uploads/", "uploads//x"} {
+		if w := upload(router, "/upload", map[string]string{"object_name": name}, "text/plain", "x"); w.Code != http.StatusBadRequest {
+			t.Errorf("POST /upload of %q = %d, want 400", name, w.Code)
+		}
+		if w := serve(router, "GET", "/download?object_name="+url.QueryEscape(name), nil); w.Code != http.StatusBadRequest {
+			t.Errorf("GET /download of %q = %d, want 400", name, w.Code)
+		}
+	}
+
+	// The old filesystem parameters are gone
+	w := serve(router, "POST", "/upload", map[string]string{"file_path": "/etc/passwd", "object_name": "uploads/passwd"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("POST /upload with file_path = %d, want 400", w.Code)
+	}
+}
+
+// stubAuth accepts the tokens it maps to an account.
+type stubAuth map[string]services.Account
+
+func (a stubAuth) Authenticate(ctx context.Context, token string) (*services.Account, error) {
+	account, ok := a[token]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown token", services.ErrUnauthenticated)
+	}
+	return &account, nil
+}
+
+func TestAuthScopesDataPerAccount(t *testing.T) {
+	places, firecrawl := newFixtures(t)
+	svc := newTestServices(t, places, firecrawl)
+	svc.Auth = stubAuth{"alice-token": {UserID: "alice"}, "bob-token": {UserID: "bob"}}
+	router := New(Config{}, svc, log.New(io.Discard, "", 0)).Router()
+
+	if w := serve(router, "GET", "/categories", nil); w.Code != http.StatusOK {
+		t.Errorf("GET /categories without token = %d, want 200", w.Code)
+	}
+	w := serve(router, "GET", "/search?location=Austin", nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("GET /search without token = %d, want 401", w.Code)
+	}
+	var apiErr errors.APIError
+	decode(t, w, &apiErr)
+	if apiErr.Type != errors.ErrorTypeUnauthorized {
+		t.Errorf("error type = %s, want %s", apiErr.Type, errors.ErrorTypeUnauthorized)
+	}
+	if w := serveAs(router, "mallory-token", "GET", "/search?location=Austin", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /search with an invalid token = %d, want 401", w.Code)
+	}
+	if n := places.Requests(fakes.PlacesTextSearch); n != 0 {
+		t.Errorf("text search requests = %d, want none for rejected callers", n)
+	}
+
+	if w := serveAs(router, "alice-token", "GET", "/search?location=Austin", nil); w.Code != http.StatusOK {
+		t.Fatalf("GET /search as alice = %d: %s", w.Code, w.Body)
+	}
+	body := map[string]any{"productType": "Bounce House", "purchasePrice": 2000, "location": "Austin"}
+	if w := serveAs(router, "alice-token", "POST", "/analyze-purchase", body); w.Code != http.StatusOK {
+		t.Errorf("POST /analyze-purchase as alice = %d: %s", w.Code, w.Body)
+	}
+	if w := serveAs(router, "bob-token", "POST", "/analyze-purchase", body); w.Code != http.StatusNotFound {
+		t.Errorf("POST /analyze-purchase as bob = %d, want 404 for alice's data", w.Code)
+	}
+
+	w = uploadAs(router, "alice-token", "/upload", map[string]string{"object_name": "uploads/prices.csv"}, "text/csv", "a,b\n")
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /upload as alice = %d: %s", w.Code, w.Body)
+	}
+	if w := serveAs(router, "bob-token", "GET", "/download?object_name=uploads/prices.csv", nil); w.Code != http.StatusNotFound {
+		t.Errorf("GET alice's file as bob = %d, want 404", w.Code)
+	}
+	if w := serveAs(router, "alice-token", "GET", "/download?object_name=uploads/prices.csv", nil); w.Code != http.StatusOK || w.Body.String() != "a,b\n" {
+		t.Errorf("GET alice's file as alice = %d: %q", w.Code, w.Body)
+	}
+
+	w = serveAs(router, "alice-token", "POST", "/searches", map[string]any{"location": "Austin"})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST /searches as alice = %d: %s", w.Code, w.Body)
+	}
+	var job services.SearchJob
+	decode(t, w, &job)
+	if w := serveAs(router, "bob-token", "GET", "/searches/"+job.ID, nil); w.Code != http.StatusNotFound {
+		t.Errorf("GET alice's job as bob = %d, want 404", w.Code)
+	}
+	if w := serveAs(router, "alice-token", "GET", "/searches/"+job.ID, nil); w.Code != http.StatusOK {
+		t.Errorf("GET alice's job as alice = %d, want 200", w.Code)
+	}
+}
+
+func TestAPIKeyQuotas(t *testing.T) {
+	places, firecrawl := newFixtures(t)
+	svc := newTestServices(t, places, firecrawl)
+	svc.Auth = stubAuth{"admin-token": {UserID: "admin", Admin: true}, "alice-token": {UserID: "alice"}}
+	svc.APIKeys = services.NewAPIKeyService(svc.Store, log.New(io.Discard, "", 0))
+	router := New(Config{}, svc, log.New(io.Discard, "", 0)).Router()
+
+	request := map[string]any{"name": "Partner", "quotas": map[string]int{"search": 1}}
+	if w := serveAs(router, "alice-token", "POST", "/admin/api-keys", request); w.Code != http.StatusForbidden {
+		t.Errorf("POST /admin/api-keys as a user = %d, want 403", w.Code)
+	}
+	if w := serveAs(router, "admin-token", "POST", "/admin/api-keys", map[string]any{"name": "Partner", "quotas": map[string]int{"scrape": 1}}); w.Code != http.StatusBadRequest {
+		t.Errorf("POST /admin/api-keys with an unknown operation = %d, want 400", w.Code)
+	}
+
+	w := serveAs(router, "admin-token", "POST", "/admin/api-keys", request)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /admin/api-keys = %d: %s", w.Code, w.Body)
+	}
+	var issued struct {
+		APIKey services.APIKey `json:"apiKey"`
+		Secret string          `json:"secret"`
+	}
+	decode(t, w, &issued)
+	if issued.Secret == "" || issued.APIKey.Hash != "" {
+		t.Fatalf("issued key = %+v, want a secret and no hash", issued)
+	}
+
+	withKey := func(method, target string, body any) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		req := httptest.NewRequest(method, target, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", issued.Secret)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	if w := withKey("GET", "/search?location=Austin", nil); w.Code != http.StatusOK {
+		t.Fatalf("GET /search with the key = %d: %s", w.Code, w.Body)
+	}
+	searches := places.Requests(fakes.PlacesTextSearch)
+
+	// Invalid and cached searches don't count against the quota
+	if w := withKey("GET", "/search?lat=30.27", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("GET /search without lng = %d, want 400", w.Code)
+	}
+	if w := withKey("GET", "/search?location=Austin%20Texas", nil); w.Code != http.StatusOK {
+		t.Errorf("cached GET /search over quota = %d, want 200", w.Code)
+	}
+
+	w = withKey("GET", "/search?location=Austin&refresh=true", nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("GET /search over quota = %d, want 429", w.Code)
+	}
+	var apiErr errors.APIError
+	decode(t, w, &apiErr)
+	if apiErr.Type != errors.ErrorTypeQuotaExceeded || w.Header().Get("Retry-After") == "" {
+		t.Errorf("over quota error = %+v, Retry-After %q", apiErr, w.Header().Get("Retry-After"))
+	}
+	// Queued searches are refused up front rather than failing in the
+	// background
+	if w := withKey("POST", "/searches", map[string]any{"location": "Austin", "refresh": true}); w.Code != http.StatusTooManyRequests {
+		t.Errorf("POST /searches over quota = %d, want 429: %s", w.Code, w.Body)
+	}
+	if n := places.Requests(fakes.PlacesTextSearch); n != searches {
+		t.Errorf("text search requests grew to %d over quota", n)
+	}
+	// Analysis isn't metered, and sees the data the key's searches stored
+	if w := withKey("GET", "/analysis/prices?location=Austin&category=bounce-house", nil); w.Code != http.StatusOK {
+		t.Errorf("GET /analysis/prices with the key = %d: %s", w.Code, w.Body)
+	}
+	if w := withKey("POST", "/admin/api-keys", nil); w.Code != http.StatusForbidden {
+		t.Errorf("POST /admin/api-keys with an API key = %d, want 403", w.Code)
+	}
+
+	w = serveAs(router, "admin-token", "GET", "/admin/api-keys/"+issued.APIKey.ID, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /admin/api-keys/:id = %d: %s", w.Code, w.Body)
+	}
+	var key services.APIKey
+	decode(t, w, &key)
+	month := time.Now().UTC().Format("2006-01")
+	if key.Usage[month][services.OperationSearch] != 1 {
+		t.Errorf("usage = %v, want one search this month", key.Usage)
+	}
+
+	if w := serveAs(router, "admin-token", "DELETE", "/admin/api-keys/"+issued.APIKey.ID, nil); w.Code != http.StatusOK {
+		t.Fatalf("DELETE /admin/api-keys/:id = %d: %s", w.Code, w.Body)
+	}
+	if w := withKey("GET", "/analysis/prices?location=Austin&category=bounce-house", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET with a revoked key = %d, want 401", w.Code)
+	}
+}
+
+// stubSearchJobs is a SearchJobs that knows a single job.
+type stubSearchJobs struct {
+	job *services.SearchJob
+}
+
+func (s stubSearchJobs) Submit(ctx context.Context, area services.SearchArea, filter services.CompetitorFilter, freshness services.Freshness) (*services.SearchJob, error) {
+	return nil, fmt.Errorf("search of %s: %w", area, services.ErrQueueFull)
+}
+
+func (s stubSearchJobs) Get(ctx context.Context, id string) (*services.SearchJob, bool) {
+	return s.job, id == s.job.ID
+}
+
+type failingSearcher struct{}
+
+func (failingSearcher) SearchCompetitors(ctx context.Context, area services.SearchArea, freshness services.Freshness) (*services.CompetitorSearchResult, error) {
+	return nil, errors.NewExternalError("places", fmt.Errorf("quota exceeded"))
+}
+
+func TestRouterWithStubServices(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := New(Config{}, Services{
+		Competitors: failingSearcher{},
+		SearchJobs:  stubSearchJobs{job: &services.SearchJob{ID: "abc", Status: services.JobStatusCompleted}},
+	}, log.New(io.Discard, "", 0)).Router()
+
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{"/searches/abc", http.StatusOK},
+		{"/searches/missing", http.StatusNotFound},
+		{"/search?location=Austin", http.StatusServiceUnavailable},
+		{"/search", http.StatusBadRequest},
+		{"/search?lat=30.27", http.StatusBadRequest},
+		{"/search?location=Austin&radius=5000", http.StatusBadRequest},
+		{"/search?lat=30.27&lng=-97.74&radius=90000", http.StatusBadRequest},
+		{"/search?lat=91&lng=-97.74", http.StatusBadRequest},
+		{"/search?lat=30.27&lng=-97.74&radius=5000", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		if w := serve(router, "GET", tt.target, nil); w.Code != tt.want {
+			t.Errorf("GET %s = %d, want %d", tt.target, w.Code, tt.want)
+		}
+	}
+
+	w := serve(router, "POST", "/searches", map[string]any{"location": "Austin"})
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("POST /searches with a full queue = %d, Retry-After %q, want 503 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func serve(router http.Handler, method, target string, body any) *httptest.ResponseRecorder {
+	return serveAs(router, "", method, target, body)
+}
+
+// serveAs sends token as bearer token unless it is empty.
+func serveAs(router http.Handler, token, method, target string, body any) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, target, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// upload posts fields followed by a "file" part holding content.
+func upload(router http.Handler, target string, fields map[string]string, contentType, content string) *httptest.ResponseRecorder {
+	return uploadAs(router, "", target, fields, contentType, content)
+}
+
+// uploadAs sends token as bearer token unless it is empty.
+func uploadAs(router http.Handler, token, target string, fields map[string]string, contentType, content string) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for name, value := range fields {
+		mw.WriteField(name, value)
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="file"; filename="upload"`)
+	header.Set("Content-Type", contentType)
+	part, _ := mw.CreatePart(header)
+	part.Write([]byte(content))
+	mw.Close()
+
+	req := httptest.NewRequest("POST", target, &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	firebase "firebase.google.com/go"
+	"firebase.google.com/go/auth"
+)
+
+// ErrUnauthenticated is returned (wrapped) when a caller's credentials can't
+// be verified.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Account is the customer a request is made for. Stored searches and
+// analyses are scoped to it; see accountID.
+type Account struct {
+	UserID string `json:"userId"`
+	// TenantID is set for users of a Firebase Auth tenant. All users of a
+	// tenant share its data.
+	TenantID string `json:"tenantId,omitempty"`
+	// Admin is set for users with the "admin" custom claim. It doesn't
+	// change the account's data scope.
+	Admin bool `json:"admin,omitempty"`
+}
+
+type accountContextKey struct{}
+
+// ContextWithAccount returns a copy of ctx carrying account.
+func ContextWithAccount(ctx context.Context, account Account) context.Context {
+	return context.WithValue(ctx, accountContextKey{}, account)
+}
+
+// AccountFromContext returns the account ctx carries, if any.
+func AccountFromContext(ctx context.Context) (Account, bool) {
+	account, ok := ctx.Value(accountContextKey{}).(Account)
+	return account, ok
+}
+
+// accountScope is the storage scope of the account ctx carries, or "" for
+// unscoped data when it carries none.
+func accountScope(ctx context.Context) string {
+	account, ok := AccountFromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return accountID(account)
+}
+
+// FirebaseAuth verifies Firebase Auth ID tokens.
+type FirebaseAuth struct {
+	client *auth.Client
+}
+
+func NewFirebaseAuth(ctx context.Context, app *firebase.App) (*FirebaseAuth, error) {
+	client, err := app.Auth(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing firebase auth client: %v", err)
+	}
+	return &FirebaseAuth{client: client}, nil
+}
+
+// Authenticate returns the account of a verified ID token. Tokens of users
+// that were disabled, deleted or had their tokens revoked are rejected like
+// any other invalid token, with an error wrapping ErrUnauthenticated. This
+// looks the user up on every call, as VerifyIDTokenAndCheckRevoked does,
+// which doesn't catch disabled users.
+func (fa *FirebaseAuth) Authenticate(ctx context.Context, idToken string) (*Account, error) {
+	token, err := fa.client.VerifyIDToken(ctx, idToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	if strings.TrimSpace(token.UID) == "" {
+		return nil, fmt.Errorf("%w: token has no uid", ErrUnauthenticated)
+	}
+
+	users, err := fa.users(token.Firebase.Tenant)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	user, err := users.GetUser(ctx, token.UID)
+	switch {
+	case auth.IsUserNotFound(err):
+		return nil, fmt.Errorf("%w: user %s no longer exists", ErrUnauthenticated, token.UID)
+	case err != nil:
+		return nil, fmt.Errorf("error looking up user %s: %v", token.UID, err)
+	case user.Disabled:
+		return nil, fmt.Errorf("%w: user %s is disabled", ErrUnauthenticated, token.UID)
+	case token.IssuedAt*1000 < user.TokensValidAfterMillis:
+		return nil, fmt.Errorf("%w: token of user %s has been revoked", ErrUnauthenticated, token.UID)
+	}
+
+	admin, _ := token.Claims["admin"].(bool)
+	return &Account{UserID: token.UID, TenantID: token.Firebase.Tenant, Admin: admin}, nil
+}
+
+// userGetter looks up users of the project or of one of its tenants.
+type userGetter interface {
+	GetUser(ctx context.Context, uid string) (*auth.UserRecord, error)
+}
+
+// users returns the client holding the users of tenant, or of the project
+// itself when tenant is "".
+func (fa *FirebaseAuth) users(tenant string) (userGetter, error) {
+	if tenant == "" {
+		return fa.client, nil
+	}
+	return fa.client.TenantManager.AuthForTenant(tenant)
+}
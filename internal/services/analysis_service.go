@@ -2,54 +2,93 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sort"
 )
 
+// ErrNoProducts is returned (wrapped) when no stored competitor of a location
+// offers products of the category being analyzed.
+var ErrNoProducts = errors.New("no products found")
+
 type AnalysisService struct {
-	firebase *FirebaseService
-	logger   *log.Logger
+	store     Store
+	locations *LocationResolver
+	logger    *log.Logger
 }
 
-func NewAnalysisService(firebase *FirebaseService, logger *log.Logger) *AnalysisService {
+func NewAnalysisService(store Store, locations *LocationResolver, logger *log.Logger) *AnalysisService {
 	return &AnalysisService{
-		firebase: firebase,
-		logger:   logger,
+		store:     store,
+		locations: locations,
+		logger:    logger,
+	}
+}
+
+// AnalyzePurchase projects the cash flow of buying a unit of category for
+// purchasePrice and renting it at the local market rate. Only competitors
+// passing filter set the market rate.
+func (as *AnalysisService) AnalyzePurchase(ctx context.Context, locationName, category string, purchasePrice float64, costs PurchaseCosts, filter CompetitorFilter) (*PurchaseAnalysis, error) {
+	rates, err := as.dailyRates(ctx, locationName, category, filter)
+	if err != nil {
+		return nil, err
 	}
+
+	return analyzePurchase(purchasePrice, rates, costs.withDefaults()), nil
 }
 
-func (as *AnalysisService) CalculateAveragePrice(ctx context.Context, locationName, category string) (float64, error) {
-	// Retrieve location data from Firebase
-	location, err := as.firebase.GetLocation(ctx, locationName)
+// PriceDistribution returns price statistics for category in the location,
+// overall and broken down by the competitors passing filter.
+func (as *AnalysisService) PriceDistribution(ctx context.Context, locationName, category string, filter CompetitorFilter) (*PriceDistribution, error) {
+	location, err := as.getLocation(ctx, locationName)
 	if err != nil {
-		return 0, fmt.Errorf("error retrieving location data: %v", err)
+		return nil, err
 	}
 
-	// Calculate the average price for the given category
-	var total float64
-	var count int
-	for _, competitor := range location.Competitors {
+	distribution := buildPriceDistribution(location.Name, filter.Apply(location.Competitors), category)
+	if distribution == nil {
+		return nil, fmt.Errorf("%w for category %s", ErrNoProducts, category)
+	}
+	return distribution, nil
+}
+
+// dailyRates returns the per-day rate of every product of category stored for
+// the location by a competitor passing filter, sorted ascending.
+func (as *AnalysisService) dailyRates(ctx context.Context, locationName, category string, filter CompetitorFilter) ([]float64, error) {
+	location, err := as.getLocation(ctx, locationName)
+	if err != nil {
+		return nil, err
+	}
+
+	var rates []float64
+	for _, competitor := range filter.Apply(location.Competitors) {
 		for _, product := range competitor.Products {
-			if product.Category == category {
-				total += product.Price
-				count++
+			if productCategory(product) == category {
+				rates = append(rates, product.DailyRate())
 			}
 		}
 	}
 
-	if count == 0 {
-		return 0, fmt.Errorf("no products found for category %s", category)
+	if len(rates) == 0 {
+		return nil, fmt.Errorf("%w for category %s", ErrNoProducts, category)
 	}
 
-	averagePrice := total / float64(count)
-	return averagePrice, nil
+	sort.Float64s(rates)
+	return rates, nil
 }
 
-func (as *AnalysisService) CalculateBreakEvenPoint(purchasePrice, averagePrice float64) (int, error) {
-	if averagePrice == 0 {
-		return 0, fmt.Errorf("average price cannot be zero")
+// getLocation retrieves the stored data of the canonical location
+// locationName resolves to.
+func (as *AnalysisService) getLocation(ctx context.Context, locationName string) (*Location, error) {
+	canonical, err := as.locations.Resolve(ctx, locationName)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving location %s: %w", locationName, err)
 	}
 
-	breakEvenPoint := int(purchasePrice / averagePrice)
-	return breakEvenPoint, nil
+	location, err := as.store.GetLocation(ctx, canonical.Key)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving location data: %w", err)
+	}
+	return location, nil
 }
@@ -0,0 +1,352 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrQuotaExceeded is returned (wrapped in a *QuotaExceededError) when an API
+// key has used up its monthly quota of an operation.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Operation is an expensive operation API key quotas are counted in.
+type Operation string
+
+// OperationSearch is a competitor search, which fans out to paid Places and
+// Firecrawl calls.
+const OperationSearch Operation = "search"
+
+// Operations lists every operation a quota can be set for.
+func Operations() []Operation {
+	return []Operation{OperationSearch}
+}
+
+// apiKeyPrefix starts every API key so leaked keys are easy to recognize.
+const apiKeyPrefix = "br_"
+
+// usageMonthFormat keys the usage counters of APIKey by calendar month (UTC).
+const usageMonthFormat = "2006-01"
+
+// apiKeyIDPattern matches the IDs newAPIKeyID generates.
+var apiKeyIDPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// APIKey lets a server-to-server client call the API as Account. Only a hash
+// of the secret is kept.
+type APIKey struct {
+	ID      string  `json:"id"`
+	Name    string  `json:"name"`
+	Account Account `json:"account"`
+	// Hash is only set on keys read straight from the store.
+	Hash string `json:"hash,omitempty"`
+	// Quotas are monthly limits; operations without one are unlimited.
+	Quotas map[Operation]int64 `json:"quotas,omitempty"`
+	// Usage counts the operations made each month.
+	Usage     map[string]map[Operation]int64 `json:"usage,omitempty"`
+	CreatedAt time.Time                      `json:"createdAt"`
+	RevokedAt *time.Time                     `json:"revokedAt,omitempty"`
+}
+
+// APIKeyRequest describes a key to issue. The key acts as its own account
+// unless UserID or TenantID are given.
+type APIKeyRequest struct {
+	Name     string              `json:"name"`
+	UserID   string              `json:"userId"`
+	TenantID string              `json:"tenantId"`
+	Quotas   map[Operation]int64 `json:"quotas"`
+}
+
+// Validate reports the first problem with the request.
+func (r APIKeyRequest) Validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	for operation, limit := range r.Quotas {
+		if !isOperation(operation) {
+			return fmt.Errorf("unknown operation %s", operation)
+		}
+		if limit < 0 {
+			return fmt.Errorf("quota of %s cannot be negative", operation)
+		}
+	}
+	return nil
+}
+
+func isOperation(operation Operation) bool {
+	for _, known := range Operations() {
+		if operation == known {
+			return true
+		}
+	}
+	return false
+}
+
+// QuotaExceededError reports the quota an operation ran into.
+type QuotaExceededError struct {
+	Operation Operation `json:"operation"`
+	Limit     int64     `json:"limit"`
+	Used      int64     `json:"used"`
+	ResetsAt  time.Time `json:"resetsAt"`
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("monthly %s quota of %d exhausted until %s", e.Operation, e.Limit, e.ResetsAt.Format(time.RFC3339))
+}
+
+func (e *QuotaExceededError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// QuotaFunc counts one operation against the quota of a caller. It returns a
+// *QuotaExceededError without counting once the quota is used up.
+type QuotaFunc func(ctx context.Context, operation Operation) error
+
+type quotaContextKey struct{}
+
+// ContextWithQuota returns a copy of ctx whose metered operations are counted
+// by quota. Operations are only counted once they are about to run, so
+// invalid requests and searches answered from stored results are free.
+func ContextWithQuota(ctx context.Context, quota QuotaFunc) context.Context {
+	return context.WithValue(ctx, quotaContextKey{}, quota)
+}
+
+// QuotaFromContext returns the QuotaFunc ctx carries, if any.
+func QuotaFromContext(ctx context.Context) (QuotaFunc, bool) {
+	quota, ok := ctx.Value(quotaContextKey{}).(QuotaFunc)
+	return quota, ok
+}
+
+// consumeQuota counts operation against the quota ctx carries. Callers
+// without one are unmetered.
+func consumeQuota(ctx context.Context, operation Operation) error {
+	quota, ok := QuotaFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return quota(ctx, operation)
+}
+
+// APIKeyService issues, verifies and meters API keys. Keys are changed with
+// Store.UpdateAPIKey, so several processes can share a bucket without losing
+// usage counts.
+type APIKeyService struct {
+	store  Store
+	logger *log.Logger
+	now    func() time.Time
+}
+
+func NewAPIKeyService(store Store, logger *log.Logger) *APIKeyService {
+	return &APIKeyService{
+		store:  store,
+		logger: logger,
+		now:    time.Now,
+	}
+}
+
+// Issue creates a key and returns it together with its secret, which can't
+// be recovered later.
+func (ks *APIKeyService) Issue(ctx context.Context, request APIKeyRequest) (*APIKey, string, error) {
+	if err := request.Validate(); err != nil {
+		return nil, "", err
+	}
+
+	id, secret, err := newAPIKeySecret()
+	if err != nil {
+		return nil, "", fmt.Errorf("error generating api key: %v", err)
+	}
+
+	account := Account{UserID: request.UserID, TenantID: request.TenantID}
+	if account.UserID == "" {
+		account.UserID = "api-key:" + id
+	}
+	key := APIKey{
+		ID:        id,
+		Name:      strings.TrimSpace(request.Name),
+		Account:   account,
+		Hash:      hashAPIKey(secret),
+		Quotas:    request.Quotas,
+		CreatedAt: ks.now().UTC(),
+	}
+	if err := ks.store.StoreAPIKey(ctx, key); err != nil {
+		return nil, "", err
+	}
+
+	ks.logger.Printf("API key %s issued for %s", id, key.Name)
+	key.Hash = ""
+	return &key, secret, nil
+}
+
+// Revoke disables a key for good.
+func (ks *APIKeyService) Revoke(ctx context.Context, id string) (*APIKey, error) {
+	revoked := false
+	key, err := ks.update(ctx, id, func(key *APIKey) error {
+		revoked = key.RevokedAt == nil
+		if revoked {
+			revokedAt := ks.now().UTC()
+			key.RevokedAt = &revokedAt
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		ks.logger.Printf("API key %s revoked", id)
+	}
+
+	key.Hash = ""
+	return key, nil
+}
+
+// Get returns a key with its usage.
+func (ks *APIKeyService) Get(ctx context.Context, id string) (*APIKey, error) {
+	key, err := ks.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	key.Hash = ""
+	return key, nil
+}
+
+// List returns every key, revoked ones included, oldest first.
+func (ks *APIKeyService) List(ctx context.Context) ([]APIKey, error) {
+	keys, err := ks.store.ListAPIKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		keys[i].Hash = ""
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+// Authenticate returns the key a secret belongs to. Unknown, malformed and
+// revoked keys give an error wrapping ErrUnauthenticated.
+func (ks *APIKeyService) Authenticate(ctx context.Context, secret string) (*APIKey, error) {
+	id, ok := parseAPIKeyID(secret)
+	if !ok {
+		return nil, fmt.Errorf("%w: malformed api key", ErrUnauthenticated)
+	}
+
+	key, err := ks.store.GetAPIKey(ctx, id)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil, fmt.Errorf("%w: unknown api key %s", ErrUnauthenticated, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashAPIKey(secret))) != 1 {
+		return nil, fmt.Errorf("%w: wrong secret for api key %s", ErrUnauthenticated, id)
+	}
+	if key.RevokedAt != nil {
+		return nil, fmt.Errorf("%w: api key %s is revoked", ErrUnauthenticated, id)
+	}
+
+	key.Hash = ""
+	return key, nil
+}
+
+// Consume counts one operation against the key's monthly quota. It returns a
+// *QuotaExceededError without counting once the quota is used up.
+func (ks *APIKeyService) Consume(ctx context.Context, id string, operation Operation) error {
+	_, err := ks.update(ctx, id, func(key *APIKey) error {
+		now := ks.now().UTC()
+		if err := quotaLeft(key, operation, now); err != nil {
+			return err
+		}
+
+		month := now.Format(usageMonthFormat)
+		used := key.Usage[month][operation]
+		if key.Usage == nil {
+			key.Usage = make(map[string]map[Operation]int64)
+		}
+		if key.Usage[month] == nil {
+			key.Usage[month] = make(map[Operation]int64)
+		}
+		key.Usage[month][operation] = used + 1
+		return nil
+	})
+	return err
+}
+
+// CheckQuota returns a *QuotaExceededError if the key's monthly quota for
+// operation is used up, without counting anything. Callers that run the
+// operation later, like queued searches, use it to refuse up front.
+func (ks *APIKeyService) CheckQuota(ctx context.Context, id string, operation Operation) error {
+	key, err := ks.get(ctx, id)
+	if err != nil {
+		return err
+	}
+	return quotaLeft(key, operation, ks.now().UTC())
+}
+
+// quotaLeft returns a *QuotaExceededError if key has used up its quota for
+// operation in the month of now.
+func quotaLeft(key *APIKey, operation Operation, now time.Time) error {
+	used := key.Usage[now.Format(usageMonthFormat)][operation]
+	if limit, ok := key.Quotas[operation]; ok && used >= limit {
+		return &QuotaExceededError{
+			Operation: operation,
+			Limit:     limit,
+			Used:      used,
+			ResetsAt:  time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC),
+		}
+	}
+	return nil
+}
+
+func (ks *APIKeyService) get(ctx context.Context, id string) (*APIKey, error) {
+	if !apiKeyIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("api key %s: %w", id, ErrObjectNotFound)
+	}
+	return ks.store.GetAPIKey(ctx, id)
+}
+
+func (ks *APIKeyService) update(ctx context.Context, id string, update func(key *APIKey) error) (*APIKey, error) {
+	if !apiKeyIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("api key %s: %w", id, ErrObjectNotFound)
+	}
+	return ks.store.UpdateAPIKey(ctx, id, update)
+}
+
+// newAPIKeySecret returns a new key ID and the secret embedding it, of the
+// form "br_<id>_<random>".
+func newAPIKeySecret() (id, secret string, err error) {
+	b := make([]byte, 8+32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	id = hex.EncodeToString(b[:8])
+	return id, apiKeyPrefix + id + "_" + hex.EncodeToString(b[8:]), nil
+}
+
+func parseAPIKeyID(secret string) (string, bool) {
+	rest, ok := strings.CutPrefix(secret, apiKeyPrefix)
+	if !ok {
+		return "", false
+	}
+	id, random, ok := strings.Cut(rest, "_")
+	if !ok || random == "" || !apiKeyIDPattern.MatchString(id) {
+		return "", false
+	}
+	return id, true
+}
+
+// hashAPIKey hashes a secret. Secrets are random, so a plain SHA-256 is
+// enough and keeps verification cheap.
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
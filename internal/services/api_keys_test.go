@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	ctx := context.Background()
+	keys := NewAPIKeyService(NewMemoryStore(testLogger()), testLogger())
+	now := time.Date(2024, 5, 31, 23, 0, 0, 0, time.UTC)
+	keys.now = func() time.Time { return now }
+
+	if _, _, err := keys.Issue(ctx, APIKeyRequest{Name: "Partner", Quotas: map[Operation]int64{"scrape": 1}}); err == nil {
+		t.Error("Issue accepted a quota for an unknown operation")
+	}
+
+	key, secret, err := keys.Issue(ctx, APIKeyRequest{Name: "Partner", TenantID: "acme", Quotas: map[Operation]int64{OperationSearch: 2}})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if !strings.HasPrefix(secret, apiKeyPrefix+key.ID+"_") || key.Hash != "" {
+		t.Errorf("Issue = %+v, %q", key, secret)
+	}
+	if key.Account != (Account{UserID: "api-key:" + key.ID, TenantID: "acme"}) {
+		t.Errorf("account = %+v", key.Account)
+	}
+
+	for _, bad := range []string{"", "br_nope", secret + "x", strings.Replace(secret, key.ID, "0123456789abcdef", 1), "br_../../x_y"} {
+		if _, err := keys.Authenticate(ctx, bad); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("Authenticate(%q) error = %v, want ErrUnauthenticated", bad, err)
+		}
+	}
+	got, err := keys.Authenticate(ctx, secret)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if got.ID != key.ID || got.Hash != "" {
+		t.Errorf("Authenticate = %+v", got)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := keys.Consume(ctx, key.ID, OperationSearch); err != nil {
+			t.Fatalf("Consume %d: %v", i, err)
+		}
+	}
+	err = keys.Consume(ctx, key.ID, OperationSearch)
+	var quotaErr *QuotaExceededError
+	if !errors.As(err, &quotaErr) || !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Consume over quota error = %v, want a QuotaExceededError", err)
+	}
+	if quotaErr.Used != 2 || !quotaErr.ResetsAt.Equal(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("quota error = %+v", quotaErr)
+	}
+
+	// Quotas are monthly
+	now = now.Add(2 * time.Hour)
+	if err := keys.Consume(ctx, key.ID, OperationSearch); err != nil {
+		t.Errorf("Consume in a new month: %v", err)
+	}
+	got, err = keys.Get(ctx, key.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Usage["2024-05"][OperationSearch] != 2 || got.Usage["2024-06"][OperationSearch] != 1 {
+		t.Errorf("usage = %v", got.Usage)
+	}
+
+	if _, err := keys.Revoke(ctx, key.ID); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if _, err := keys.Authenticate(ctx, secret); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("Authenticate of a revoked key error = %v, want ErrUnauthenticated", err)
+	}
+	if _, err := keys.Revoke(ctx, "../x"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Revoke of an invalid ID error = %v, want ErrObjectNotFound", err)
+	}
+
+	all, err := keys.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(all) != 1 || all[0].RevokedAt == nil || all[0].Hash != "" {
+		t.Errorf("List = %+v", all)
+	}
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Product categories. Every scraped product is classified into exactly one
+// of these, and /analyze-purchase only accepts these as productType.
+const (
+	CategoryBounceHouse     = "bounce-house"
+	CategoryCombo           = "combo"
+	CategoryWaterSlide      = "water-slide"
+	CategoryDrySlide        = "dry-slide"
+	CategoryObstacleCourse  = "obstacle-course"
+	CategoryInteractiveGame = "interactive-game"
+	CategoryDunkTank        = "dunk-tank"
+	CategoryTent            = "tent"
+	CategoryTablesChairs    = "tables-chairs"
+	CategoryConcession      = "concession"
+	CategoryOther           = "other"
+)
+
+// Categories returns the taxonomy in display order.
+func Categories() []string {
+	return []string{
+		CategoryBounceHouse,
+		CategoryCombo,
+		CategoryWaterSlide,
+		CategoryDrySlide,
+		CategoryObstacleCourse,
+		CategoryInteractiveGame,
+		CategoryDunkTank,
+		CategoryTent,
+		CategoryTablesChairs,
+		CategoryConcession,
+		CategoryOther,
+	}
+}
+
+// categoryAliases maps normalized spellings people and the extractor commonly
+// use onto a category.
+var categoryAliases = map[string]string{
+	"bounce house":       CategoryBounceHouse,
+	"bounce houses":      CategoryBounceHouse,
+	"bouncehouse":        CategoryBounceHouse,
+	"bouncer":            CategoryBounceHouse,
+	"bouncers":           CategoryBounceHouse,
+	"moonwalk":           CategoryBounceHouse,
+	"moonwalks":          CategoryBounceHouse,
+	"moon bounce":        CategoryBounceHouse,
+	"jumper":             CategoryBounceHouse,
+	"jumpers":            CategoryBounceHouse,
+	"combos":             CategoryCombo,
+	"combo unit":         CategoryCombo,
+	"combo units":        CategoryCombo,
+	"water slides":       CategoryWaterSlide,
+	"waterslide":         CategoryWaterSlide,
+	"waterslides":        CategoryWaterSlide,
+	"dry slides":         CategoryDrySlide,
+	"slide":              CategoryDrySlide,
+	"slides":             CategoryDrySlide,
+	"obstacle courses":   CategoryObstacleCourse,
+	"obstacle":           CategoryObstacleCourse,
+	"interactive games":  CategoryInteractiveGame,
+	"interactive":        CategoryInteractiveGame,
+	"game":               CategoryInteractiveGame,
+	"games":              CategoryInteractiveGame,
+	"dunk tanks":         CategoryDunkTank,
+	"tents":              CategoryTent,
+	"canopy":             CategoryTent,
+	"canopies":           CategoryTent,
+	"tables and chairs":  CategoryTablesChairs,
+	"tables chairs":      CategoryTablesChairs,
+	"tables":             CategoryTablesChairs,
+	"chairs":             CategoryTablesChairs,
+	"concessions":        CategoryConcession,
+	"concession machine": CategoryConcession,
+	"food machines":      CategoryConcession,
+}
+
+// categoryRules are checked in order against a product's name, so more
+// specific categories come before the generic ones they overlap with (a
+// "bounce house with slide" is a combo, an "obstacle course slide" is an
+// obstacle course). Words that describe a feature rather than the unit
+// itself, like "dunk", "game" or "basketball", come after the unit nouns,
+// so a "slam dunk bounce house" is still a bounce house.
+var categoryRules = []struct {
+	category string
+	phrases  []string
+}{
+	{CategoryConcession, []string{"cotton candy", "popcorn", "snow cone", "sno cone", "shaved ice", "slushy", "slushie", "nacho", "hot dog", "concession", "concessions"}},
+	{CategoryTablesChairs, []string{"table", "tables", "chair", "chairs", "linen", "linens"}},
+	{CategoryDunkTank, []string{"dunk tank", "dunk tanks"}},
+	{CategoryObstacleCourse, []string{"obstacle", "obstacles", "obstacle course", "ninja warrior", "boot camp"}},
+	{CategoryCombo, []string{"combo", "combos", "4 in 1", "5 in 1", "bounce and slide", "bouncer with slide", "bounce house with slide", "with slide"}},
+	{CategoryWaterSlide, []string{"water slide", "water slides", "waterslide", "waterslides", "wet slide", "wet dry slide", "slip n slide", "slip and slide", "splash"}},
+	{CategoryInteractiveGame, []string{"interactive", "joust", "gladiator", "bungee run", "meltdown", "wrecking ball", "sticky wall", "rock wall", "climbing wall", "mechanical bull", "axe throw"}},
+	{CategoryDrySlide, []string{"slide", "slides", "dry slide"}},
+	{CategoryBounceHouse, []string{"bounce", "bouncer", "bounce house", "bouncehouse", "moonwalk", "moon bounce", "jumper", "jump house", "castle"}},
+	{CategoryTent, []string{"tent", "tents", "canopy", "canopies", "marquee"}},
+	{CategoryDunkTank, []string{"dunk"}},
+	{CategoryInteractiveGame, []string{"game", "games", "basketball", "soccer", "boxing"}},
+	{CategoryBounceHouse, []string{"inflatable"}},
+}
+
+// ParseCategory maps s onto the taxonomy. It accepts category IDs as well as
+// common spellings such as "Bounce House" or "tables and chairs".
+func ParseCategory(s string) (string, bool) {
+	normalized := normalizeCategoryText(s)
+	if normalized == "" {
+		return "", false
+	}
+
+	id := strings.ReplaceAll(normalized, " ", "-")
+	for _, category := range Categories() {
+		if id == category {
+			return category, true
+		}
+	}
+
+	category, ok := categoryAliases[normalized]
+	return category, ok
+}
+
+// ClassifyProduct assigns a product to a category. An extraction hint that
+// names a category directly is trusted, unless it is CategoryOther, which
+// extraction falls back to whenever it is unsure; otherwise keyword rules are
+// applied to the product name and then to the hint. Products nothing matches
+// are classified as CategoryOther.
+func ClassifyProduct(name, hint string) string {
+	if category, ok := ParseCategory(hint); ok && category != CategoryOther {
+		return category
+	}
+	if category, ok := matchCategoryRules(name); ok {
+		return category
+	}
+	if category, ok := matchCategoryRules(hint); ok {
+		return category
+	}
+	return CategoryOther
+}
+
+// productCategory returns the taxonomy category of a stored product,
+// classifying it again if it predates the taxonomy.
+func productCategory(product Product) string {
+	if category, ok := ParseCategory(product.Category); ok {
+		return category
+	}
+	return ClassifyProduct(product.Name, product.Category)
+}
+
+func matchCategoryRules(text string) (string, bool) {
+	normalized := normalizeCategoryText(text)
+	if normalized == "" {
+		return "", false
+	}
+
+	padded := " " + normalized + " "
+	for _, rule := range categoryRules {
+		for _, phrase := range rule.phrases {
+			if strings.Contains(padded, " "+phrase+" ") {
+				return rule.category, true
+			}
+		}
+	}
+	return "", false
+}
+
+// normalizeCategoryText lowercases s and collapses everything that isn't a
+// letter or digit into single spaces, so phrases match on word boundaries.
+func normalizeCategoryText(s string) string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,63 @@
+package services
+
+import "testing"
+
+func TestClassifyProduct(t *testing.T) {
+	tests := []struct {
+		name, hint string
+		want       string
+	}{
+		{"Castle Bounce House", "", CategoryBounceHouse},
+		{"Princess Moonwalk", "", CategoryBounceHouse},
+		{"Tropical Castle Combo", "", CategoryCombo},
+		{"Bounce House with Slide", "", CategoryCombo},
+		{"18ft Tiki Plunge", "water slide", CategoryWaterSlide},
+		{"Double Lane Wet/Dry Slide", "", CategoryWaterSlide},
+		{"Fire & Ice Dual Lane Slide", "", CategoryDrySlide},
+		{"40ft Obstacle Course with Slide", "", CategoryObstacleCourse},
+		{"Gladiator Joust", "", CategoryInteractiveGame},
+		{"Dunk Tank", "", CategoryDunkTank},
+		{"20x20 Frame Tent", "", CategoryTent},
+		{"6ft Banquet Table", "", CategoryTablesChairs},
+		{"Inflatable Arch", "", CategoryBounceHouse},
+		{"Cotton Candy Machine", "", CategoryConcession},
+		{"Sports Combo with Basketball Hoop", "", CategoryCombo},
+		{"Slam Dunk Basketball Bounce House", "", CategoryBounceHouse},
+		{"Game Day Bounce House", "", CategoryBounceHouse},
+		{"Party Tent Bounce House", "", CategoryBounceHouse},
+		{"Inflatable Basketball Shootout Game", "", CategoryInteractiveGame},
+		{"Generator", "", CategoryOther},
+		{"Mystery Item", "combo", CategoryCombo},
+		{"Castle Bounce House", "other", CategoryBounceHouse},
+		{"Generator", "other", CategoryOther},
+	}
+
+	for _, tt := range tests {
+		if got := ClassifyProduct(tt.name, tt.hint); got != tt.want {
+			t.Errorf("ClassifyProduct(%q, %q) = %q, want %q", tt.name, tt.hint, got, tt.want)
+		}
+	}
+}
+
+func TestParseCategory(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"bounce-house", CategoryBounceHouse, true},
+		{"Bounce House", CategoryBounceHouse, true},
+		{"WATER_SLIDE", CategoryWaterSlide, true},
+		{"tables and chairs", CategoryTablesChairs, true},
+		{"bounce houses", CategoryBounceHouse, true},
+		{"spaceship", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := ParseCategory(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ParseCategory(%q) = %q, %v, want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -7,126 +7,239 @@ import (
 	"strings"
 	"sync"
 	"time"
-
-	"googlemaps.github.io/maps"
 )
 
 type CompetitorService struct {
 	firecrawl *FirecrawlClient
-	places    *maps.Client
-	firebase  *FirebaseService
+	places    *PlacesClient
+	locations *LocationResolver
+	store     Store
+	cache     CacheConfig
 	logger    *log.Logger
+	now       func() time.Time
 }
 
 type CompetitorSearchResult struct {
-	Competitors []Competitor `json:"competitors"`
-	Location    string       `json:"location"`
-	TotalFound  int          `json:"totalFound"`
+	Competitors []Competitor       `json:"competitors"`
+	Location    string             `json:"location"`
+	Canonical   *CanonicalLocation `json:"canonical,omitempty"`
+	TotalFound  int                `json:"totalFound"`
+	// CollectedAt is when the competitors were scraped. Cached is set when
+	// they come from an earlier search.
+	CollectedAt time.Time `json:"collectedAt"`
+	Cached      bool      `json:"cached"`
 }
 
 type Competitor struct {
-	Name     string    `json:"name"`
-	Website  string    `json:"website"`
-	Products []Product `json:"products"`
+	PlaceID     string    `json:"placeId,omitempty"`
+	Name        string    `json:"name"`
+	Website     string    `json:"website"`
+	Address     string    `json:"address,omitempty"`
+	Location    *LatLng   `json:"location,omitempty"`
+	Phone       string    `json:"phone,omitempty"`
+	Rating      float32   `json:"rating,omitempty"`
+	ReviewCount int       `json:"reviewCount,omitempty"`
+	Hours       []string  `json:"hours,omitempty"`
+	Products    []Product `json:"products"`
+
+	// DistanceMeters is the distance from the origin of the request. It
+	// depends on the caller, so it is never stored.
+	DistanceMeters *float64 `json:"distanceMeters,omitempty"`
 }
 
+// Product is a rental item. Price is the base rate for one rental period;
+// the remaining terms are only set when the competitor's site states them.
 type Product struct {
-	Name     string  `json:"name"`
-	Price    float64 `json:"price"`
-	URL      string  `json:"url"`
-	Category string  `json:"category"`
+	Name     string `json:"name"`
+	Price    Price  `json:"price"`
+	URL      string `json:"url"`
+	Category string `json:"category"`
+
+	RentalHours   float64 `json:"rentalHours,omitempty"`
+	ExtraHourRate float64 `json:"extraHourRate,omitempty"`
+	DeliveryFee   float64 `json:"deliveryFee,omitempty"`
+	Deposit       float64 `json:"deposit,omitempty"`
 }
 
+// ProductSchema is a single product as returned by Firecrawl extraction.
+// Price is kept as the raw string shown on the page.
 type ProductSchema struct {
-	Name     string  `json:"name"`
-	Price    float64 `json:"price"`
-	URL      string  `json:"url,omitempty"`
-	Category string  `json:"category"`
+	Name          string `json:"name"`
+	Price         string `json:"price"`
+	URL           string `json:"url,omitempty"`
+	Category      string `json:"category"`
+	RentalPeriod  string `json:"rentalPeriod,omitempty"`
+	ExtraHourRate string `json:"extraHourRate,omitempty"`
+	DeliveryFee   string `json:"deliveryFee,omitempty"`
+	Deposit       string `json:"deposit,omitempty"`
 }
 
+// ExtractSchema is the extraction result for one scraped page.
 type ExtractSchema struct {
 	Products []ProductSchema `json:"products"`
 }
 
-func NewCompetitorService(firecrawlKey, firecrawlBaseURL, placesKey, firebaseCredentialsFile, firebaseBucketName string, logger *log.Logger) (*CompetitorService, error) {
-	// Initialize Firecrawl
-	firecrawlClient, err := NewFirecrawlClient(firecrawlKey, firecrawlBaseURL)
-	if err != nil {
-		return nil, err
-	}
+// CompetitorStatus describes how far a single competitor has progressed
+// through a search.
+type CompetitorStatus string
 
-	// Initialize Places Client
-	placesClient, err := maps.NewClient(maps.WithAPIKey(placesKey))
-	if err != nil {
-		return nil, err
-	}
+const (
+	CompetitorStatusPending    CompetitorStatus = "pending"
+	CompetitorStatusProcessing CompetitorStatus = "processing"
+	CompetitorStatusCompleted  CompetitorStatus = "completed"
+	CompetitorStatusSkipped    CompetitorStatus = "skipped"
+	CompetitorStatusFailed     CompetitorStatus = "failed"
+)
 
-	// Initialize Firebase Service
-	firebaseService, err := NewFirebaseService(firebaseCredentialsFile, firebaseBucketName, logger)
-	if err != nil {
-		return nil, err
-	}
+// CompetitorProgress is reported for every place found during a search.
+type CompetitorProgress struct {
+	PlaceID      string           `json:"placeId"`
+	Name         string           `json:"name"`
+	Website      string           `json:"website,omitempty"`
+	Status       CompetitorStatus `json:"status"`
+	ProductCount int              `json:"productCount"`
+	Error        string           `json:"error,omitempty"`
+}
 
+// ProgressFunc receives competitor progress updates while a search runs.
+// It may be called concurrently from several goroutines.
+type ProgressFunc func(progress CompetitorProgress)
+
+// NewCompetitorService creates the service from its clients. The caller owns
+// the clients and the store and closes them once the service is no longer
+// used. Stored search results and scraped websites are reused as set by
+// cache.
+func NewCompetitorService(firecrawlClient *FirecrawlClient, placesClient *PlacesClient, locations *LocationResolver, store Store, cache CacheConfig, logger *log.Logger) *CompetitorService {
 	return &CompetitorService{
 		firecrawl: firecrawlClient,
 		places:    placesClient,
-		firebase:  firebaseService,
+		locations: locations,
+		store:     store,
+		cache:     cache.withDefaults(),
 		logger:    logger,
-	}, nil
+		now:       time.Now,
+	}
 }
 
-func (s *CompetitorService) SearchCompetitors(ctx context.Context, location string) (*CompetitorSearchResult, error) {
-	// Search for bounce house rental businesses in the area
-	searchRequest := &maps.TextSearchRequest{
-		Query: "bounce house rentals in " + location,
-		Type:  "business",
+// Filter returns a copy of the result holding only the competitors that
+// pass f.
+func (r *CompetitorSearchResult) Filter(f CompetitorFilter) *CompetitorSearchResult {
+	out := *r
+	out.Competitors = f.Apply(r.Competitors)
+	out.TotalFound = len(out.Competitors)
+	return &out
+}
+
+// SearchCompetitors finds the competitors in area, scrapes their products
+// and stores the result under the canonical location of the area. The stored
+// result of an earlier search is returned instead when freshness accepts it;
+// only new searches count against the quota ctx carries.
+func (s *CompetitorService) SearchCompetitors(ctx context.Context, area SearchArea, freshness Freshness) (*CompetitorSearchResult, error) {
+	return s.SearchCompetitorsWithProgress(ctx, area, freshness, nil)
+}
+
+// SearchCompetitorsWithProgress behaves like SearchCompetitors but reports the
+// state of each competitor to onProgress as it is processed.
+func (s *CompetitorService) SearchCompetitorsWithProgress(ctx context.Context, area SearchArea, freshness Freshness, onProgress ProgressFunc) (*CompetitorSearchResult, error) {
+	if err := area.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid search area: %v", err)
+	}
+	location := area.Name()
+	canonical, err := s.locations.ResolveArea(ctx, area)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving location %s: %w", location, err)
 	}
 
-	response, err := s.places.TextSearch(ctx, searchRequest)
+	report := func(progress CompetitorProgress) {
+		if onProgress != nil {
+			onProgress(progress)
+		}
+	}
+
+	if cached := s.cachedResult(ctx, canonical, area, freshness); cached != nil {
+		s.logger.Printf("Using search results for %s collected at %s", canonical.Key, cached.UpdatedAt.Format(time.RFC3339))
+		for _, competitor := range cached.Competitors {
+			report(CompetitorProgress{
+				PlaceID:      competitor.PlaceID,
+				Name:         competitor.Name,
+				Website:      competitor.Website,
+				Status:       CompetitorStatusCompleted,
+				ProductCount: len(competitor.Products),
+			})
+		}
+		return &CompetitorSearchResult{
+			Competitors: cached.Competitors,
+			Location:    location,
+			Canonical:   canonical,
+			TotalFound:  len(cached.Competitors),
+			CollectedAt: cached.UpdatedAt,
+			Cached:      true,
+		}, nil
+	}
+
+	// Only searches that call Places and Firecrawl count against a quota
+	if err := consumeQuota(ctx, OperationSearch); err != nil {
+		return nil, err
+	}
+
+	// Search for bounce house rental businesses in the area
+	places, err := s.places.Search(ctx, area)
 	if err != nil {
-		return nil, fmt.Errorf("error searching for competitors: %v", err)
+		return nil, err
 	}
 
 	// Process competitors concurrently with rate limiting
 	var wg sync.WaitGroup
-	results := make(chan Competitor, len(response.Results))
-	errs := make(chan error, len(response.Results))
+	results := make(chan Competitor, len(places))
+	errs := make(chan error, len(places))
 	semaphore := make(chan struct{}, 5) // Limit concurrent requests
 
-	for _, place := range response.Results {
+	for _, place := range places {
+		report(CompetitorProgress{PlaceID: place.PlaceID, Name: place.Name, Status: CompetitorStatusPending})
+	}
+
+	for _, place := range places {
 		wg.Add(1)
-		go func(place maps.PlacesSearchResult) {
+		go func(place Place) {
 			defer wg.Done()
 
 			semaphore <- struct{}{}        // Acquire semaphore
 			defer func() { <-semaphore }() // Release semaphore
 
 			// Get place details to get website
-			detailsReq := &maps.PlaceDetailsRequest{
-				PlaceID: place.PlaceID,
-				Fields:  []maps.PlaceDetailsFieldMask{maps.PlaceDetailsFieldMaskWebsite},
-			}
-
-			details, err := s.places.PlaceDetails(ctx, detailsReq)
+			place, err := s.places.Details(ctx, place)
 			if err != nil {
 				s.logger.Printf("Error getting place details for %s: %v", place.Name, err)
+				report(CompetitorProgress{PlaceID: place.PlaceID, Name: place.Name, Status: CompetitorStatusFailed, Error: err.Error()})
 				errs <- err
 				return
 			}
 
-			if details.Website == "" {
+			if place.Website == "" {
+				report(CompetitorProgress{PlaceID: place.PlaceID, Name: place.Name, Status: CompetitorStatusSkipped})
 				return // Skip places without websites
 			}
 
-			competitor, err := s.processCompetitor(ctx, place.Name, details.Website)
+			report(CompetitorProgress{PlaceID: place.PlaceID, Name: place.Name, Website: place.Website, Status: CompetitorStatusProcessing})
+			competitor, err := s.processCompetitor(ctx, place)
 			if err != nil {
 				s.logger.Printf("Error processing competitor %s: %v", place.Name, err)
+				report(CompetitorProgress{PlaceID: place.PlaceID, Name: place.Name, Website: place.Website, Status: CompetitorStatusFailed, Error: err.Error()})
 				errs <- err
 				return
 			}
-			if competitor != nil {
-				results <- *competitor
+			if competitor == nil {
+				report(CompetitorProgress{PlaceID: place.PlaceID, Name: place.Name, Website: place.Website, Status: CompetitorStatusSkipped})
+				return
 			}
+			report(CompetitorProgress{
+				PlaceID:      place.PlaceID,
+				Name:         place.Name,
+				Website:      place.Website,
+				Status:       CompetitorStatusCompleted,
+				ProductCount: len(competitor.Products),
+			})
+			results <- *competitor
 		}(place)
 	}
 
@@ -144,89 +257,97 @@ func (s *CompetitorService) SearchCompetitors(ctx context.Context, location stri
 	}
 
 	// Check for errors
+	failed := 0
 	for err := range errs {
 		s.logger.Printf("Error encountered: %v", err)
+		failed++
+	}
+
+	// Persist the snapshot so analysis has data to read and later searches
+	// of the area can be answered from it. A search that collected nothing
+	// would only hide the last snapshot that did.
+	collectedAt := s.now().UTC()
+	snapshot := Location{
+		Key:         canonical.Key,
+		Name:        canonical.Name,
+		Canonical:   canonical,
+		Area:        &area,
+		Competitors: competitors,
+		Failed:      failed,
+	}
+	if len(competitors) == 0 {
+		s.logger.Printf("No competitors collected for %s, keeping the stored search results", canonical.Key)
+	} else {
+		if err := s.store.StoreSnapshot(ctx, snapshot); err != nil {
+			s.logger.Printf("Error storing search results for %s: %v", canonical.Key, err)
+		}
 	}
 
 	return &CompetitorSearchResult{
 		Competitors: competitors,
 		Location:    location,
+		Canonical:   canonical,
 		TotalFound:  len(competitors),
+		CollectedAt: collectedAt,
 	}, nil
 }
 
-func (s *CompetitorService) processCompetitor(ctx context.Context, name, website string) (*Competitor, error) {
-	// First try to map the website
-	s.logger.Printf("Mapping website: %s", website)
-	mapResponse, err := s.firecrawl.MapWebsite(ctx, website)
-	if err != nil {
-		s.logger.Printf("Error mapping website %s: %v", website, err)
-		// Continue with crawl as fallback
-	}
-
-	// Wait for the map process to complete
-	time.Sleep(3 * time.Second)
+// processCompetitor scrapes the products on place's website. Websites are
+// read through the site cache, so a competitor found by several searches is
+// only mapped and scraped again once its cached pages changed or expired.
+func (s *CompetitorService) processCompetitor(ctx context.Context, place Place) (*Competitor, error) {
+	website := place.Website
 
-	var relevantURLs []string
-	if mapResponse != nil && mapResponse.Links != nil {
-		s.logger.Printf("Found %d links from mapping for website %s", len(mapResponse.Links), website)
-		relevantURLs = filterRelevantURLs(mapResponse.Links)
-	}
-
-	if len(relevantURLs) == 0 {
-		// If no relevant URLs found through mapping, try crawling
-		s.logger.Printf("No relevant URLs found for website %s, falling back to crawl", website)
-		crawlResponse, err := s.firecrawl.CrawlWebsite(ctx, website, nil, 500)
-		if err != nil {
-			s.logger.Printf("Error initiating crawl for website %s: %v", website, err)
-			return nil, err
-		}
-
-		if crawlResponse != nil && crawlResponse.Success {
-			crawlID := crawlResponse.ID
-			s.logger.Printf("Crawl initiated for website %s with ID %s", website, crawlID)
-			statusResponse, err := s.firecrawl.GetCrawlStatus(ctx, crawlID)
-			if err != nil {
-				s.logger.Printf("Error checking crawl status for crawl ID %s: %v", crawlID, err)
-				return nil, err
-			}
-
-			// Collect links from each FirecrawlDocument
-			for _, doc := range statusResponse.Data {
-				relevantURLs = append(relevantURLs, doc.Links...)
-			}
-			s.logger.Printf("Crawl completed for website %s, found %d links", website, len(relevantURLs))
-			relevantURLs = filterRelevantURLs(relevantURLs)
-		}
+	siteMap, err := s.siteMap(ctx, website)
+	if err != nil {
+		return nil, err
 	}
 
 	// Extract product information from relevant pages
-	var products []Product
-	for _, url := range relevantURLs {
-		s.logger.Printf("Extracting products from URL: %s", url)
-		extractedProducts, err := s.firecrawl.ScrapeWebsite(ctx, url)
+	var (
+		products   []Product
+		extractErr error
+	)
+	for _, url := range siteMap.Pages {
+		page, err := s.scrapedPage(ctx, website, url)
 		if err != nil {
 			s.logger.Printf("Error extracting products from %s: %v", url, err)
+			extractErr = err
 			continue // Skip failed extractions
 		}
 
-		// Wait for the scrape process to complete
-		time.Sleep(3 * time.Second)
-
-		products = append(products, extractedProducts)
+		products = append(products, page.Products...)
 	}
 
+	// The same product is often listed on several pages
+	products = dedupeProducts(products)
+
 	if len(products) == 0 {
+		// Without products from the pages that failed the competitor
+		// wasn't collected, rather than found to list nothing
+		if extractErr != nil {
+			return nil, extractErr
+		}
 		s.logger.Printf("No products found for website %s", website)
 		return nil, nil // Skip if no products found
 	}
 
 	s.logger.Printf("Found %d products for website %s", len(products), website)
-	return &Competitor{
-		Name:     name,
-		Website:  website,
-		Products: products,
-	}, nil
+	competitor := &Competitor{
+		PlaceID:     place.PlaceID,
+		Name:        place.Name,
+		Website:     website,
+		Address:     place.Address,
+		Phone:       place.Phone,
+		Rating:      place.Rating,
+		ReviewCount: place.ReviewCount,
+		Hours:       place.Hours,
+		Products:    products,
+	}
+	if place.Lat != 0 || place.Lng != 0 {
+		competitor.Location = &LatLng{Lat: place.Lat, Lng: place.Lng}
+	}
+	return competitor, nil
 }
 
 func filterRelevantURLs(urls []string) []string {
@@ -248,6 +369,30 @@ func filterRelevantURLs(urls []string) []string {
 	return relevant
 }
 
+// dedupeProducts removes products listed more than once by the same
+// competitor, matching on name case- and whitespace-insensitively. The first
+// occurrence wins, with missing fields filled from later ones.
+func dedupeProducts(products []Product) []Product {
+	seen := make(map[string]int, len(products))
+	var deduped []Product
+	for _, product := range products {
+		key := strings.Join(strings.Fields(strings.ToLower(product.Name)), " ")
+		i, ok := seen[key]
+		if !ok {
+			seen[key] = len(deduped)
+			deduped = append(deduped, product)
+			continue
+		}
+		if deduped[i].Category == "" {
+			deduped[i].Category = product.Category
+		}
+		if deduped[i].Price.IsZero() {
+			deduped[i].Price = product.Price
+		}
+	}
+	return deduped
+}
+
 func BoolPtr(b bool) *bool {
 	return &b
 }
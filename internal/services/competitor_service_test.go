@@ -0,0 +1,61 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDedupeProducts(t *testing.T) {
+	castle := Price{Min: 150, Max: 150}
+	slide := Price{Min: 300, Max: 350}
+
+	tests := []struct {
+		name string
+		in   []Product
+		want []Product
+	}{
+		{
+			name: "case and whitespace",
+			in: []Product{
+				{Name: "Castle Bounce House", Price: castle},
+				{Name: "  castle   BOUNCE house ", Price: slide},
+			},
+			want: []Product{{Name: "Castle Bounce House", Price: castle}},
+		},
+		{
+			name: "first wins in order",
+			in: []Product{
+				{Name: "Tiki Slide", Price: slide, URL: "/slide", Category: CategoryWaterSlide},
+				{Name: "Castle", Price: castle},
+				{Name: "tiki slide", Price: castle, URL: "/other", Category: CategoryDrySlide},
+			},
+			want: []Product{
+				{Name: "Tiki Slide", Price: slide, URL: "/slide", Category: CategoryWaterSlide},
+				{Name: "Castle", Price: castle},
+			},
+		},
+		{
+			name: "missing fields filled from later duplicates",
+			in: []Product{
+				{Name: "Castle"},
+				{Name: "castle", Category: CategoryBounceHouse},
+				{Name: "CASTLE", Price: castle, Category: CategoryCombo},
+			},
+			want: []Product{{Name: "Castle", Price: castle, Category: CategoryBounceHouse}},
+		},
+		{
+			name: "distinct names kept",
+			in:   []Product{{Name: "Castle"}, {Name: "Castle XL"}},
+			want: []Product{{Name: "Castle"}, {Name: "Castle XL"}},
+		},
+		{
+			name: "empty",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := dedupeProducts(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: dedupeProducts = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
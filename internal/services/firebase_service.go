@@ -2,30 +2,29 @@ package services
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"os"
+	"net/http"
 
 	"cloud.google.com/go/storage"
 	firebase "firebase.google.com/go"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
+// FirebaseService is the Store backed by a Firebase Storage (GCS) bucket.
 type FirebaseService struct {
+	*objectStore
 	app     *firebase.App
 	storage *storage.Client
 	bucket  *storage.BucketHandle
 	logger  *log.Logger
 }
 
-type Location struct {
-	Name        string       `json:"name"`
-	Competitors []Competitor `json:"competitors"`
-}
-
-func NewFirebaseService(credentialsFilePath, bucketName string, logger *log.Logger) (*FirebaseService, error) {
+func NewFirebaseService(credentialsFilePath, bucketName string, retry RetryPolicy, logger *log.Logger) (*FirebaseService, error) {
 	// Initialize Firebase app
 	opt := option.WithCredentialsFile(credentialsFilePath)
 	app, err := firebase.NewApp(context.Background(), nil, opt)
@@ -42,123 +41,133 @@ func NewFirebaseService(credentialsFilePath, bucketName string, logger *log.Logg
 	bucket := storageClient.Bucket(bucketName)
 
 	return &FirebaseService{
-		app:     app,
-		storage: storageClient,
-		bucket:  bucket,
-		logger:  logger,
+		objectStore: newObjectStore(gcsBackend{bucket: bucket}, retry, logger),
+		app:         app,
+		storage:     storageClient,
+		bucket:      bucket,
+		logger:      logger,
 	}, nil
 }
 
-func (fs *FirebaseService) UploadFile(ctx context.Context, filePath, objectName string) error {
-	f, err := os.Open(filePath)
-	if err != nil {
-		return fmt.Errorf("error opening file: %v", err)
-	}
-	defer f.Close()
+// Auth returns a verifier of ID tokens issued by the Firebase project of the
+// bucket.
+func (fs *FirebaseService) Auth(ctx context.Context) (*FirebaseAuth, error) {
+	return NewFirebaseAuth(ctx, fs.app)
+}
 
-	wc := fs.bucket.Object(objectName).NewWriter(ctx)
-	if _, err = io.Copy(wc, f); err != nil {
-		return fmt.Errorf("error uploading file to firebase storage: %v", err)
+// Close releases the GCS client.
+func (fs *FirebaseService) Close() error {
+	if err := fs.storage.Close(); err != nil {
+		return fmt.Errorf("error closing storage client: %v", err)
 	}
-	if err := wc.Close(); err != nil {
-		return fmt.Errorf("error closing writer: %v", err)
-	}
-
-	fs.logger.Printf("File %s uploaded to %s", filePath, objectName)
 	return nil
 }
 
-func (fs *FirebaseService) DownloadFile(ctx context.Context, objectName, destPath string) error {
-	rc, err := fs.bucket.Object(objectName).NewReader(ctx)
-	if err != nil {
-		return fmt.Errorf("error creating reader: %v", err)
-	}
-	defer rc.Close()
+// gcsBackend stores objects in a GCS bucket.
+type gcsBackend struct {
+	bucket *storage.BucketHandle
+}
 
-	f, err := os.Create(destPath)
-	if err != nil {
-		return fmt.Errorf("error creating file: %v", err)
-	}
-	defer f.Close()
+func (b gcsBackend) newWriter(ctx context.Context, name, contentType string) io.WriteCloser {
+	w := b.bucket.Object(name).NewWriter(ctx)
+	w.ContentType = contentType
+	return w
+}
 
-	if _, err = io.Copy(f, rc); err != nil {
-		return fmt.Errorf("error downloading file from firebase storage: %v", err)
+func (b gcsBackend) newWriterIf(ctx context.Context, name, contentType string, generation int64) io.WriteCloser {
+	conditions := storage.Conditions{GenerationMatch: generation}
+	if generation == 0 {
+		conditions = storage.Conditions{DoesNotExist: true}
 	}
-
-	fs.logger.Printf("File %s downloaded to %s", objectName, destPath)
-	return nil
+	w := b.bucket.Object(name).If(conditions).NewWriter(ctx)
+	w.ContentType = contentType
+	return gcsConditionalWriter{Writer: w, name: name}
 }
 
-func (fs *FirebaseService) StoreLocation(ctx context.Context, location Location) error {
-	locationData, err := json.Marshal(location)
+func (b gcsBackend) readGeneration(ctx context.Context, name string) ([]byte, int64, error) {
+	r, err := b.bucket.Object(name).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, 0, fmt.Errorf("%s: %w", name, ErrObjectNotFound)
+	}
 	if err != nil {
-		return fmt.Errorf("error marshaling location data: %v", err)
+		return nil, 0, err
 	}
+	defer r.Close()
 
-	objectName := fmt.Sprintf("%s/location.json", location.Name)
-	wc := fs.bucket.Object(objectName).NewWriter(ctx)
-	if _, err = wc.Write(locationData); err != nil {
-		return fmt.Errorf("error writing location data to firebase storage: %v", err)
-	}
-	if err := wc.Close(); err != nil {
-		return fmt.Errorf("error closing writer: %v", err)
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
 	}
-
-	fs.logger.Printf("Location %s stored in %s", location.Name, objectName)
-	return nil
+	return data, r.Attrs.Generation, nil
 }
 
-func (fs *FirebaseService) StoreCompetitor(ctx context.Context, locationName string, competitor Competitor) error {
-	competitorData, err := json.Marshal(competitor)
-	if err != nil {
-		return fmt.Errorf("error marshaling competitor data: %v", err)
+func (b gcsBackend) newReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	rc, err := b.bucket.Object(name).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, fmt.Errorf("%s: %w", name, ErrObjectNotFound)
 	}
+	return rc, err
+}
 
-	objectName := fmt.Sprintf("%s/%s/competitor", locationName, competitor.Name)
-	wc := fs.bucket.Object(objectName).NewWriter(ctx)
-	if _, err = wc.Write(competitorData); err != nil {
-		return fmt.Errorf("error writing competitor data to firebase storage: %v", err)
-	}
-	if err := wc.Close(); err != nil {
-		return fmt.Errorf("error closing writer: %v", err)
+func (b gcsBackend) newRangeReader(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	rc, err := b.bucket.Object(name).NewRangeReader(ctx, offset, length)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, fmt.Errorf("%s: %w", name, ErrObjectNotFound)
 	}
-
-	fs.logger.Printf("Competitor %s stored in %s", competitor.Name, objectName)
-	return nil
+	return rc, err
 }
 
-func (fs *FirebaseService) StoreProduct(ctx context.Context, locationName, competitorName, category string, product Product) error {
-	productData, err := json.Marshal(product)
+func (b gcsBackend) stat(ctx context.Context, name string) (ObjectAttrs, error) {
+	attrs, err := b.bucket.Object(name).Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return ObjectAttrs{}, fmt.Errorf("%s: %w", name, ErrObjectNotFound)
+	}
 	if err != nil {
-		return fmt.Errorf("error marshaling product data: %v", err)
+		return ObjectAttrs{}, err
 	}
+	return ObjectAttrs{
+		Name:        attrs.Name,
+		Size:        attrs.Size,
+		ContentType: attrs.ContentType,
+		Updated:     attrs.Updated,
+	}, nil
+}
 
-	objectName := fmt.Sprintf("%s/%s/%s/%s.json", locationName, competitorName, category, product.Name)
-	wc := fs.bucket.Object(objectName).NewWriter(ctx)
-	if _, err = wc.Write(productData); err != nil {
-		return fmt.Errorf("error writing product data to firebase storage: %v", err)
-	}
-	if err := wc.Close(); err != nil {
-		return fmt.Errorf("error closing writer: %v", err)
+func (b gcsBackend) list(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	it := b.bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, attrs.Name)
 	}
-
-	fs.logger.Printf("Product %s stored in %s", product.Name, objectName)
-	return nil
 }
 
-func (fs *FirebaseService) GetLocation(ctx context.Context, locationName string) (*Location, error) {
-	objectName := fmt.Sprintf("%s/location.json", locationName)
-	rc, err := fs.bucket.Object(objectName).NewReader(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("error creating reader: %v", err)
+func (b gcsBackend) delete(ctx context.Context, name string) error {
+	err := b.bucket.Object(name).Delete(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("%s: %w", name, ErrObjectNotFound)
 	}
-	defer rc.Close()
+	return err
+}
 
-	var location Location
-	if err := json.NewDecoder(rc).Decode(&location); err != nil {
-		return nil, fmt.Errorf("error decoding location data: %v", err)
-	}
+// gcsConditionalWriter reports a failed generation precondition as
+// errGenerationMismatch.
+type gcsConditionalWriter struct {
+	*storage.Writer
+	name string
+}
 
-	fs.logger.Printf("Location %s retrieved from %s", locationName, objectName)
-	return &location, nil
+func (w gcsConditionalWriter) Close() error {
+	err := w.Writer.Close()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed {
+		return fmt.Errorf("%s: %w", w.name, errGenerationMismatch)
+	}
+	return err
 }
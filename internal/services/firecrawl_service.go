@@ -3,13 +3,15 @@ package services
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
-	"strconv"
-	"sync"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/mendableai/firecrawl-go"
@@ -20,7 +22,11 @@ type FirecrawlClient struct {
 	apiKey  string
 	baseURL string
 	Client  *firecrawl.FirecrawlApp
-	limiter *RateLimiter
+	limits  *RateLimits
+	retry   RetryPolicy
+	http    *http.Client
+
+	crawlTimeout time.Duration
 }
 
 type MapParams struct {
@@ -40,186 +46,298 @@ type ExtractPrompt struct {
 	ExtractPrompt string
 }
 
-type CrawlResponse struct {
-	// Define the fields based on the expected response
-}
+// Crawl job states reported by the Firecrawl status endpoint.
+const (
+	crawlStatusCompleted = "completed"
+	crawlStatusFailed    = "failed"
+	crawlStatusCancelled = "cancelled"
+)
 
-type StatusResponse struct {
-	// Define the fields based on the expected response
-}
+// scrapeUserAgent is sent to competitor sites by Firecrawl when scraping.
+const scrapeUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36"
 
-type RateLimiter struct {
-	tokens        int
-	maxTokens     int
-	tokenInterval time.Duration
-	mu            sync.Mutex
-}
+const (
+	defaultCrawlTimeout      = 10 * time.Minute
+	crawlPollInitialInterval = 2 * time.Second
+	crawlPollMaxInterval     = 30 * time.Second
+)
 
-func NewRateLimiter(maxTokens int, tokenInterval time.Duration) *RateLimiter {
-	rl := &RateLimiter{
-		tokens:        maxTokens,
-		maxTokens:     maxTokens,
-		tokenInterval: tokenInterval,
+// NewFireCrawlClient creates a new instance of FireCrawlClient. crawlTimeout
+// bounds how long WaitForCrawl waits for a job; zero uses the default.
+// Requests wait on the Firecrawl and per-domain budgets of limits, which may
+// be shared with other clients; nil uses DefaultRateLimitConfig. Transient
+// failures are retried according to retry.
+func NewFirecrawlClient(apiKey string, baseURL string, crawlTimeout time.Duration, limits *RateLimits, retry RetryPolicy) (*FirecrawlClient, error) {
+	// baseURL includes the API version ("https://api.firecrawl.dev/v1/"),
+	// but FirecrawlApp appends "/v1/..." itself.
+	appURL := strings.TrimSuffix(strings.TrimSuffix(baseURL, "/"), "/v1")
+	client, err := firecrawl.NewFirecrawlApp(apiKey, appURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize FirecrawlApp: %v", err)
 	}
+	httpClient := newHTTPClient()
+	client.Client = httpClient
 
-	go rl.refillTokens()
+	if crawlTimeout <= 0 {
+		crawlTimeout = defaultCrawlTimeout
+	}
 
-	return rl
-}
+	if limits == nil {
+		limits = NewRateLimits(DefaultRateLimitConfig())
+	}
 
-func (rl *RateLimiter) refillTokens() {
-	ticker := time.NewTicker(rl.tokenInterval)
-	defer ticker.Stop()
+	return &FirecrawlClient{
+		apiKey:       apiKey,
+		baseURL:      baseURL,
+		Client:       client,
+		limits:       limits,
+		retry:        retry,
+		http:         httpClient,
+		crawlTimeout: crawlTimeout,
+	}, nil
+}
 
-	for range ticker.C {
-		rl.mu.Lock()
-		if rl.tokens < rl.maxTokens {
-			rl.tokens++
+// wait blocks until the budget of target's domain, when target is set, and
+// then the Firecrawl budget allow another request. The domain comes first so
+// requests queued behind a slow domain don't hold Firecrawl tokens that
+// requests for other domains could use.
+func (fc *FirecrawlClient) wait(ctx context.Context, target string) error {
+	if target != "" {
+		if err := fc.limits.Domain(target).Wait(ctx); err != nil {
+			return err
 		}
-		rl.mu.Unlock()
 	}
+	return fc.limits.Firecrawl.Wait(ctx)
 }
 
-func (rl *RateLimiter) Allow() bool {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	if rl.tokens > 0 {
-		rl.tokens--
-		return true
+// request sends a request to the Firecrawl API on behalf of target and
+// returns the response body. Every attempt waits on the rate limits, and
+// transient failures are retried.
+func (fc *FirecrawlClient) request(ctx context.Context, target, method, url string, payload any) ([]byte, error) {
+	var jsonBody []byte
+	if payload != nil {
+		var err error
+		jsonBody, err = json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request body: %v", err)
+		}
 	}
 
-	return false
-}
+	var body []byte
+	err := fc.retry.Do(ctx, func() error {
+		if err := fc.wait(ctx, target); err != nil {
+			return err
+		}
 
-// NewFireCrawlClient creates a new instance of FireCrawlClient.
-func NewFirecrawlClient(apiKey string, baseURL string) (*FirecrawlClient, error) {
-	client, err := firecrawl.NewFirecrawlApp(apiKey, baseURL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize FirecrawlApp: %v", err)
-	}
+		req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(jsonBody))
+		if err != nil {
+			return fmt.Errorf("failed to create request: %v", err)
+		}
+		if payload != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set("Authorization", "Bearer "+fc.apiKey)
 
-	return &FirecrawlClient{
-		apiKey:  apiKey,
-		baseURL: baseURL,
-		Client:  client,
-		limiter: NewRateLimiter(5, time.Second), // 5 requests per second
-	}, nil
-}
+		resp, err := fc.http.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to execute request: %w", err)
+		}
+		defer resp.Body.Close()
 
-// CrawlWebsite initiates a new crawl job for the given website.
-func (fc *FirecrawlClient) CrawlWebsite(ctx context.Context, website string, options interface{}, limit int) (*firecrawl.CrawlResponse, error) {
-	if !fc.limiter.Allow() {
-		return nil, fmt.Errorf("rate limit exceeded")
-	}
+		body, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read response body: %w", err)
+		}
+		return nil
+	})
+	return body, err
+}
 
+// CrawlWebsite initiates a new crawl job for the given website and returns the
+// job ID in the response.
+func (fc *FirecrawlClient) CrawlWebsite(ctx context.Context, website string, scrapeOptions *firecrawl.ScrapeParams, limit int) (*firecrawl.CrawlResponse, error) {
 	url := fmt.Sprintf("%scrawl", fc.baseURL)
 	requestBody := map[string]interface{}{
-		"website": website,
-		"limit":   limit,
-		"options": options,
+		"url":   website,
+		"limit": limit,
 	}
-
-	jsonBody, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request body: %v", err)
+	if scrapeOptions != nil {
+		requestBody["scrapeOptions"] = scrapeOptions
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBody))
+	body, err := fc.request(ctx, website, "POST", url, requestBody)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+		return nil, fmt.Errorf("failed to crawl website: %w", err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+fc.apiKey)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %v", err)
+	var crawlResponse firecrawl.CrawlResponse
+	if err := json.Unmarshal(body, &crawlResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse crawl response: %v", err)
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+	if !crawlResponse.Success || crawlResponse.ID == "" {
+		return nil, fmt.Errorf("failed to crawl website: no job ID in response")
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to crawl website: %s", string(body))
+	return &crawlResponse, nil
+}
+
+// GetCrawlStatus fetches the current status and first page of results of a
+// crawl job.
+func (fc *FirecrawlClient) GetCrawlStatus(ctx context.Context, crawlID string) (*firecrawl.CrawlStatusResponse, error) {
+	return fc.getCrawlStatusPage(ctx, fmt.Sprintf("%scrawl/%s", fc.baseURL, crawlID))
+}
+
+// WaitForCrawl polls a crawl job with exponential backoff until it completes,
+// fails or is cancelled, then follows the next links to collect every
+// document. The whole operation is bounded by the client's crawl timeout.
+// Unless the crawl's documents are returned or Firecrawl reports it failed or
+// cancelled, the job is cancelled on a best-effort basis.
+func (fc *FirecrawlClient) WaitForCrawl(ctx context.Context, crawlID string) (*firecrawl.CrawlStatusResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, fc.crawlTimeout)
+	defer cancel()
+
+	done := false
+	defer func() {
+		if !done {
+			fc.cancelCrawl(crawlID)
+		}
+	}()
+
+	interval := crawlPollInitialInterval
+	for {
+		status, err := fc.GetCrawlStatus(ctx, crawlID)
+		switch {
+		case err != nil && !errors.Is(err, ErrRateLimited):
+			return nil, err
+		case err == nil && status.Status == crawlStatusCompleted:
+			result, err := fc.collectCrawlPages(ctx, status)
+			done = err == nil
+			return result, err
+		case err == nil && (status.Status == crawlStatusFailed || status.Status == crawlStatusCancelled):
+			done = true
+			return nil, fmt.Errorf("crawl %s finished with status %s", crawlID, status.Status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for crawl %s: %w", crawlID, ctx.Err())
+		case <-time.After(interval):
+		}
+
+		interval *= 2
+		if interval > crawlPollMaxInterval {
+			interval = crawlPollMaxInterval
+		}
 	}
+}
 
-	var crawlResponse CrawlResponse
-	if err := json.Unmarshal(body, &crawlResponse); err != nil {
-		return nil, fmt.Errorf("failed to parse crawl response: %v", err)
+// collectCrawlPages follows the next links of a completed crawl and returns a
+// single response holding every document.
+func (fc *FirecrawlClient) collectCrawlPages(ctx context.Context, status *firecrawl.CrawlStatusResponse) (*firecrawl.CrawlStatusResponse, error) {
+	result := *status
+	result.Next = nil
+
+	next := status.Next
+	for next != nil && *next != "" {
+		page, err := fc.getCrawlStatusPage(ctx, *next)
+		if err != nil {
+			return nil, err
+		}
+		result.Data = append(result.Data, page.Data...)
+		next = page.Next
 	}
 
-	return &firecrawl.CrawlResponse{}, nil
+	return &result, nil
 }
 
-func (fc *FirecrawlClient) GetCrawlStatus(ctx context.Context, crawlID string) (*firecrawl.CrawlStatusResponse, error) {
-	if !fc.limiter.Allow() {
-		return nil, fmt.Errorf("rate limit exceeded")
+func (fc *FirecrawlClient) getCrawlStatusPage(ctx context.Context, url string) (*firecrawl.CrawlStatusResponse, error) {
+	body, err := fc.request(ctx, "", "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get crawl status: %w", err)
 	}
 
-	url := fmt.Sprintf("%sstatus?crawl_id=%s", fc.baseURL, crawlID)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+	var statusResponse firecrawl.CrawlStatusResponse
+	if err := json.Unmarshal(body, &statusResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse status response: %v", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+fc.apiKey)
+	return &statusResponse, nil
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %v", err)
-	}
-	defer resp.Body.Close()
+// cancelCrawl asks Firecrawl to stop a crawl job we are no longer waiting
+// for. It uses its own short deadline because the caller's context has
+// usually already expired.
+func (fc *FirecrawlClient) cancelCrawl(crawlID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", fmt.Sprintf("%scrawl/%s", fc.baseURL, crawlID), nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+		return
 	}
+	req.Header.Set("Authorization", "Bearer "+fc.apiKey)
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get crawl status: %s", string(body))
+	resp, err := fc.http.Do(req)
+	if err != nil {
+		return
 	}
+	resp.Body.Close()
+}
 
-	var statusResponse StatusResponse
-	if err := json.Unmarshal(body, &statusResponse); err != nil {
-		return nil, fmt.Errorf("failed to parse status response: %v", err)
+// ScrapeWebsite extracts every product listed on the page at pageURL. Listing
+// pages such as /rentals or /inventory typically return many products;
+// detail pages return one.
+func (fc *FirecrawlClient) ScrapeWebsite(ctx context.Context, pageURL string) ([]Product, error) {
+	page, err := fc.ScrapePage(ctx, pageURL)
+	if err != nil {
+		return nil, err
 	}
-
-	return &firecrawl.CrawlStatusResponse{}, nil
+	return page.Products, nil
 }
 
-func (fc *FirecrawlClient) ScrapeWebsite(ctx context.Context, productURL string) (Product, error) {
-	if !fc.limiter.Allow() {
-		return Product{}, fmt.Errorf("rate limit exceeded")
+// ScrapePage extracts the products on the page at pageURL like ScrapeWebsite
+// and also returns the hash of the page content they were extracted from.
+// The timestamps of the page are left for the caller to set.
+func (fc *FirecrawlClient) ScrapePage(ctx context.Context, pageURL string) (*ScrapedPage, error) {
+	productSchema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name":          map[string]interface{}{"type": "string"},
+			"price":         map[string]interface{}{"type": "string"},
+			"url":           map[string]interface{}{"type": "string"},
+			"category":      map[string]interface{}{"type": "string", "enum": Categories()},
+			"rentalPeriod":  map[string]interface{}{"type": "string"},
+			"extraHourRate": map[string]interface{}{"type": "string"},
+			"deliveryFee":   map[string]interface{}{"type": "string"},
+			"deposit":       map[string]interface{}{"type": "string"},
+		},
+		"required": []string{"name", "price"},
 	}
 
 	extractSchema := map[string]interface{}{
 		"type": "object",
 		"properties": map[string]interface{}{
-			"name":  map[string]interface{}{"type": "string"},
-			"price": map[string]interface{}{"type": "string"},
-			"url":   map[string]interface{}{"type": "string"},
+			"products": map[string]interface{}{
+				"type":  "array",
+				"items": productSchema,
+			},
 		},
-		"required": []string{"name", "price", "url"},
+		"required": []string{"products"},
 	}
 
-	extractPrompt := "Extract the main product from the page, including name and price. If a price range is given, only include the lowest price. Return the url of the page as well. Return the data as a JSON object with \"name\", \"price\", and \"url\" fields."
+	extractPrompt := "Extract every rental product listed on the page. For each product return its \"name\", its \"price\" exactly as shown, the \"url\" of the product's own page if it links to one, and the \"category\" that best describes the item, chosen from: " + strings.Join(Categories(), ", ") + ". When the page states them, also return the \"rentalPeriod\" the price covers (for example \"8 hours\", \"per day\" or \"weekend\"), the \"extraHourRate\" for additional hours, the \"deliveryFee\" and the \"deposit\", each exactly as shown. Return the data as a JSON object with a \"products\" array."
 
+	// The markdown is only requested for its content hash
 	scrapeParams := &firecrawl.ScrapeParams{
-		Formats: []string{"extract"},
+		Formats: []string{"extract", "markdown"},
 		Headers: &map[string]string{
-			"User-Agent": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36",
+			"User-Agent": scrapeUserAgent,
 		},
 	}
 
 	requestBody := map[string]interface{}{
-		"url":     productURL,
+		"url":     pageURL,
 		"formats": scrapeParams.Formats,
 		"headers": scrapeParams.Headers,
 		"extract": map[string]interface{}{
@@ -228,72 +346,156 @@ func (fc *FirecrawlClient) ScrapeWebsite(ctx context.Context, productURL string)
 		},
 	}
 
-	jsonBody, err := json.Marshal(requestBody)
+	result, err := fc.scrape(ctx, pageURL, requestBody)
 	if err != nil {
-		return Product{}, fmt.Errorf("failed to marshal request body: %v", err)
+		return nil, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", fc.baseURL+"scrape", bytes.NewBuffer(jsonBody))
+	extracted, err := decodeExtract(result.Extract)
 	if err != nil {
-		return Product{}, fmt.Errorf("failed to create request: %v", err)
+		return nil, fmt.Errorf("failed to unmarshal extracted data: %v", err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+fc.apiKey)
+	var products []Product
+	for _, p := range extracted.Products {
+		name := strings.TrimSpace(p.Name)
+		if name == "" {
+			continue
+		}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+		price, err := ParsePrice(p.Price)
+		if err != nil {
+			continue // Skip products without a usable price
+		}
+
+		// The rental period is often only stated next to the price
+		if price.Unit == PriceUnitUnknown {
+			price.Unit = parsePriceUnit(p.RentalPeriod)
+		}
+
+		products = append(products, Product{
+			Name:          name,
+			Price:         price,
+			URL:           resolveProductURL(pageURL, p.URL),
+			Category:      ClassifyProduct(name, p.Category),
+			RentalHours:   parseRentalHours(p.Price + " " + p.RentalPeriod),
+			ExtraHourRate: parseOptionalAmount(p.ExtraHourRate),
+			DeliveryFee:   parseOptionalAmount(p.DeliveryFee),
+			Deposit:       parseOptionalAmount(p.Deposit),
+		})
+	}
+
+	return &ScrapedPage{
+		URL:         pageURL,
+		Products:    products,
+		ContentHash: contentHash(result.Markdown),
+	}, nil
+}
+
+// PageContentHash returns the hash of the content of the page at pageURL, as
+// stored in ScrapedPage.ContentHash, without extracting products. A plain
+// scrape costs a fraction of an extraction, so it is used to tell whether a
+// page scraped earlier has changed. The hash is "" if Firecrawl returned no
+// content.
+func (fc *FirecrawlClient) PageContentHash(ctx context.Context, pageURL string) (string, error) {
+	requestBody := map[string]interface{}{
+		"url":     pageURL,
+		"formats": []string{"markdown"},
+		"headers": map[string]string{
+			"User-Agent": scrapeUserAgent,
+		},
+	}
+
+	result, err := fc.scrape(ctx, pageURL, requestBody)
 	if err != nil {
-		return Product{}, fmt.Errorf("failed to execute request: %v", err)
+		return "", err
 	}
-	defer resp.Body.Close()
+	return contentHash(result.Markdown), nil
+}
+
+// scrapeResult holds the formats of a scrape response we read.
+type scrapeResult struct {
+	Extract  json.RawMessage `json:"extract"`
+	Markdown string          `json:"markdown"`
+}
 
-	body, err := io.ReadAll(resp.Body)
+func (fc *FirecrawlClient) scrape(ctx context.Context, pageURL string, requestBody map[string]interface{}) (*scrapeResult, error) {
+	body, err := fc.request(ctx, pageURL, "POST", fc.baseURL+"scrape", requestBody)
 	if err != nil {
-		return Product{}, fmt.Errorf("failed to read response: %v", err)
+		return nil, fmt.Errorf("failed to scrape website: %w", err)
 	}
 
 	var result struct {
-		Data struct {
-			Extract string `json:"extract"`
-		} `json:"data"`
+		Data scrapeResult `json:"data"`
 	}
 	if err := json.Unmarshal(body, &result); err != nil {
-		return Product{}, fmt.Errorf("failed to parse response: %v", err)
+		return nil, fmt.Errorf("failed to parse response: %v", err)
 	}
+	return &result.Data, nil
+}
 
-	var extractedProduct map[string]interface{}
-	if err := json.Unmarshal([]byte(result.Data.Extract), &extractedProduct); err != nil {
-		return Product{}, fmt.Errorf("failed to unmarshal extracted data: %v", err)
+// contentHash hashes the markdown Firecrawl renders a page to. Markdown
+// leaves out scripts, styles and markup that change on every request, so the
+// hash only changes with what the page shows.
+func contentHash(markdown string) string {
+	if strings.TrimSpace(markdown) == "" {
+		return ""
 	}
+	sum := sha256.Sum256([]byte(markdown))
+	return hex.EncodeToString(sum[:])
+}
 
-	price, err := strconv.ParseFloat(extractedProduct["price"].(string), 64)
-	if err != nil {
-		return Product{}, fmt.Errorf("failed to parse price for product %s: %v", extractedProduct["name"], err)
+// decodeExtract accepts the extract either as a JSON object or as a string
+// containing JSON, since both have been returned by Firecrawl.
+func decodeExtract(raw json.RawMessage) (ExtractSchema, error) {
+	var extracted ExtractSchema
+	if len(raw) > 0 && raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return extracted, err
+		}
+		raw = json.RawMessage(s)
 	}
+	err := json.Unmarshal(raw, &extracted)
+	return extracted, err
+}
 
-	product := Product{
-		Name:  extractedProduct["name"].(string),
-		Price: price,
-		URL:   extractedProduct["url"].(string),
+// resolveProductURL makes productURL absolute relative to the page it was
+// found on, falling back to the page itself when no URL was extracted.
+func resolveProductURL(pageURL, productURL string) string {
+	productURL = strings.TrimSpace(productURL)
+	if productURL == "" {
+		return pageURL
 	}
-
-	return product, nil
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return productURL
+	}
+	ref, err := url.Parse(productURL)
+	if err != nil {
+		return pageURL
+	}
+	return base.ResolveReference(ref).String()
 }
 
 // MapWebsite initiates a new map job for the given website.
 func (fc *FirecrawlClient) MapWebsite(ctx context.Context, website string) (*MapResponse, error) {
-	if !fc.limiter.Allow() {
-		return nil, fmt.Errorf("rate limit exceeded")
-	}
-
 	if fc.Client == nil {
 		return nil, fmt.Errorf("FirecrawlApp client is not initialized")
 	}
 
-	resp, err := fc.Client.MapURL(website, nil)
+	var resp *firecrawl.MapResponse
+	err := fc.retry.Do(ctx, func() error {
+		if err := fc.wait(ctx, website); err != nil {
+			return err
+		}
+
+		var err error
+		resp, err = fc.Client.MapURL(website, nil)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to map website: %v", err)
+		return nil, fmt.Errorf("failed to map website: %w", err)
 	}
 
 	if !resp.Success {
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaitForCrawlFollowsPagination(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/v1/crawl":
+			json.NewEncoder(w).Encode(map[string]any{"success": true, "id": "job-1"})
+		case r.URL.Path == "/v1/crawl/job-1" && r.URL.Query().Get("skip") == "":
+			json.NewEncoder(w).Encode(map[string]any{
+				"status": "completed",
+				"next":   srv.URL + "/v1/crawl/job-1?skip=1",
+				"data":   []map[string]any{{"links": []string{"https://a.example/rentals"}}},
+			})
+		case r.URL.Path == "/v1/crawl/job-1":
+			json.NewEncoder(w).Encode(map[string]any{
+				"status": "completed",
+				"data":   []map[string]any{{"links": []string{"https://a.example/inventory"}}},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	fc, err := NewFirecrawlClient("test-key", srv.URL+"/v1/", 0, nil, RetryPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	crawl, err := fc.CrawlWebsite(ctx, "https://a.example", nil, 10)
+	if err != nil {
+		t.Fatalf("CrawlWebsite: %v", err)
+	}
+	if crawl.ID != "job-1" {
+		t.Fatalf("crawl ID = %q, want job-1", crawl.ID)
+	}
+
+	status, err := fc.WaitForCrawl(ctx, crawl.ID)
+	if err != nil {
+		t.Fatalf("WaitForCrawl: %v", err)
+	}
+	if len(status.Data) != 2 {
+		t.Fatalf("got %d documents, want 2", len(status.Data))
+	}
+	if status.Next != nil {
+		t.Errorf("Next = %q, want nil after collecting all pages", *status.Next)
+	}
+}
+
+func TestWaitForCrawlFailedJob(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"status": "failed"})
+	}))
+	defer srv.Close()
+
+	fc, err := NewFirecrawlClient("test-key", srv.URL+"/v1/", 0, nil, RetryPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fc.WaitForCrawl(context.Background(), "job-1")
+	if err == nil || !strings.Contains(err.Error(), "failed") {
+		t.Errorf("WaitForCrawl error = %v, want failed status", err)
+	}
+}
+
+func TestWaitForCrawlCancelsAbandonedJob(t *testing.T) {
+	tests := []struct {
+		name   string
+		status http.HandlerFunc
+	}{
+		{"deadline during status request", func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}},
+		{"status error", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, `{"success":false}`, http.StatusBadRequest)
+		}},
+		{"next page error", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("skip") != "" {
+				http.Error(w, `{"success":false}`, http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{
+				"status": "completed",
+				"next":   "http://" + r.Host + "/v1/crawl/job-1?skip=1",
+			})
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cancelled := make(chan struct{}, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "DELETE" && r.URL.Path == "/v1/crawl/job-1" {
+					cancelled <- struct{}{}
+					return
+				}
+				tt.status(w, r)
+			}))
+			defer srv.Close()
+
+			fc, err := NewFirecrawlClient("test-key", srv.URL+"/v1/", 50*time.Millisecond, nil, RetryPolicy{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := fc.WaitForCrawl(context.Background(), "job-1"); err == nil {
+				t.Fatal("WaitForCrawl succeeded")
+			}
+			select {
+			case <-cancelled:
+			default:
+				t.Error("WaitForCrawl returned without cancelling the crawl")
+			}
+		})
+	}
+}
+
+func TestScrapeWebsiteReturnsEveryProduct(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"success": true,
+			"data": map[string]any{
+				"extract": map[string]any{
+					"products": []map[string]any{
+						{"name": "Castle Bounce House", "price": "150", "url": "/rentals/castle", "category": "bounce house"},
+						{"name": "Tropical Water Slide", "price": "275"},
+						{"name": "Call for pricing", "price": "call"},
+					},
+				},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	fc, err := NewFirecrawlClient("test-key", srv.URL+"/v1/", 0, nil, RetryPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	products, err := fc.ScrapeWebsite(context.Background(), "https://a.example/rentals")
+	if err != nil {
+		t.Fatalf("ScrapeWebsite: %v", err)
+	}
+	if len(products) != 2 {
+		t.Fatalf("got %d products, want 2: %+v", len(products), products)
+	}
+	if products[0].URL != "https://a.example/rentals/castle" {
+		t.Errorf("URL = %q, want resolved product URL", products[0].URL)
+	}
+	if products[1].URL != "https://a.example/rentals" {
+		t.Errorf("URL = %q, want page URL fallback", products[1].URL)
+	}
+}
+
+func TestWaitTakesDomainTokenFirst(t *testing.T) {
+	limits := NewRateLimits(RateLimitConfig{
+		Firecrawl: RateBudget{Requests: 1, Per: time.Hour},
+		Domain:    RateBudget{Requests: 1, Per: time.Hour},
+	})
+	fc, err := NewFirecrawlClient("test-key", "http://firecrawl.invalid/v1/", 0, limits, RetryPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits.Domain("https://jumpinjacks.example/").Allow()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := fc.wait(ctx, "https://jumpinjacks.example/rentals"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("wait() = %v, want ErrRateLimited", err)
+	}
+	// The Firecrawl token is left for other domains
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := fc.wait(ctx, "https://bouncebros.example/"); err != nil {
+		t.Errorf("wait() for another domain = %v", err)
+	}
+}
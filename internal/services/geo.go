@@ -0,0 +1,92 @@
+package services
+
+import (
+	"fmt"
+	"math"
+)
+
+// earthRadiusMeters is the mean radius used for great-circle distances.
+const earthRadiusMeters = 6371000
+
+// LatLng is a point on the earth in decimal degrees.
+type LatLng struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+func (p LatLng) String() string {
+	return fmt.Sprintf("%.6f,%.6f", p.Lat, p.Lng)
+}
+
+// Validate reports whether p is a valid coordinate.
+func (p LatLng) Validate() error {
+	if p.Lat < -90 || p.Lat > 90 {
+		return fmt.Errorf("lat must be between -90 and 90")
+	}
+	if p.Lng < -180 || p.Lng > 180 {
+		return fmt.Errorf("lng must be between -180 and 180")
+	}
+	return nil
+}
+
+// DistanceMeters returns the great-circle distance between p and q.
+func (p LatLng) DistanceMeters(q LatLng) float64 {
+	lat1, lat2 := radians(p.Lat), radians(q.Lat)
+	dLat := lat2 - lat1
+	dLng := radians(q.Lng - p.Lng)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+// CompetitorFilter narrows competitors to those near the caller's business.
+// The zero value keeps every competitor.
+type CompetitorFilter struct {
+	// Origin is the caller's location. Competitors get their distance from
+	// it set when it is given.
+	Origin *LatLng `json:"origin,omitempty"`
+	// MaxDistanceMeters drops competitors further than this from Origin,
+	// along with those whose location is unknown. Zero means no limit.
+	MaxDistanceMeters float64 `json:"maxDistanceMeters,omitempty"`
+}
+
+// Validate reports the first problem with the filter.
+func (f CompetitorFilter) Validate() error {
+	if f.MaxDistanceMeters < 0 {
+		return fmt.Errorf("maxDistance cannot be negative")
+	}
+	if f.MaxDistanceMeters > 0 && f.Origin == nil {
+		return fmt.Errorf("maxDistance requires an origin")
+	}
+	if f.Origin != nil {
+		return f.Origin.Validate()
+	}
+	return nil
+}
+
+// Apply returns copies of the competitors that pass the filter, with their
+// distance from Origin set.
+func (f CompetitorFilter) Apply(competitors []Competitor) []Competitor {
+	if f.Origin == nil {
+		return competitors
+	}
+
+	filtered := make([]Competitor, 0, len(competitors))
+	for _, competitor := range competitors {
+		competitor.DistanceMeters = nil
+		if competitor.Location != nil {
+			distance := f.Origin.DistanceMeters(*competitor.Location)
+			competitor.DistanceMeters = &distance
+		}
+
+		if f.MaxDistanceMeters > 0 && (competitor.DistanceMeters == nil || *competitor.DistanceMeters > f.MaxDistanceMeters) {
+			continue
+		}
+		filtered = append(filtered, competitor)
+	}
+	return filtered
+}
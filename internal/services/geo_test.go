@@ -0,0 +1,75 @@
+package services
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDistanceMeters(t *testing.T) {
+	austin := LatLng{Lat: 30.2672, Lng: -97.7431}
+	tests := []struct {
+		name string
+		to   LatLng
+		want float64
+	}{
+		{"same point", austin, 0},
+		{"Georgetown", LatLng{Lat: 30.6333, Lng: -97.6780}, 41100},
+		{"San Antonio", LatLng{Lat: 29.4241, Lng: -98.4936}, 118700},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := austin.DistanceMeters(tt.to)
+			if math.Abs(got-tt.want) > 500 {
+				t.Errorf("DistanceMeters = %.0f, want about %.0f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompetitorFilterApply(t *testing.T) {
+	competitors := []Competitor{
+		{Name: "near", Location: &LatLng{Lat: 30.27, Lng: -97.74}},
+		{Name: "far", Location: &LatLng{Lat: 30.6333, Lng: -97.6780}},
+		{Name: "unknown"},
+	}
+	origin := &LatLng{Lat: 30.2672, Lng: -97.7431}
+
+	if got := (CompetitorFilter{}).Apply(competitors); len(got) != 3 {
+		t.Errorf("zero filter kept %d competitors, want 3", len(got))
+	}
+
+	got := CompetitorFilter{Origin: origin}.Apply(competitors)
+	if len(got) != 3 || got[0].DistanceMeters == nil || got[2].DistanceMeters != nil {
+		t.Errorf("origin only = %+v, want every competitor with distances where known", got)
+	}
+
+	got = CompetitorFilter{Origin: origin, MaxDistanceMeters: 20000}.Apply(competitors)
+	if len(got) != 1 || got[0].Name != "near" {
+		t.Errorf("within 20km = %+v, want only near", got)
+	}
+	if competitors[0].DistanceMeters != nil {
+		t.Error("Apply modified its input")
+	}
+}
+
+func TestCompetitorFilterValidate(t *testing.T) {
+	origin := &LatLng{Lat: 30.2672, Lng: -97.7431}
+	tests := []struct {
+		name    string
+		filter  CompetitorFilter
+		wantErr bool
+	}{
+		{"zero", CompetitorFilter{}, false},
+		{"origin and distance", CompetitorFilter{Origin: origin, MaxDistanceMeters: 10000}, false},
+		{"distance without origin", CompetitorFilter{MaxDistanceMeters: 10000}, true},
+		{"negative distance", CompetitorFilter{Origin: origin, MaxDistanceMeters: -1}, true},
+		{"invalid origin", CompetitorFilter{Origin: &LatLng{Lat: 91}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.filter.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
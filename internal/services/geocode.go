@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"googlemaps.github.io/maps"
+)
+
+// ErrLocationNotFound is returned (wrapped) when a location can't be
+// geocoded.
+var ErrLocationNotFound = errors.New("location not found")
+
+// CanonicalLocation is a geocoded location. Every spelling of a location
+// resolves to the same CanonicalLocation, and Key names its data in storage.
+type CanonicalLocation struct {
+	Key     string `json:"key"`
+	PlaceID string `json:"placeId"`
+	// Name is the formatted address given by the geocoder, such as
+	// "Austin, TX, USA".
+	Name    string `json:"name"`
+	City    string `json:"city,omitempty"`
+	State   string `json:"state,omitempty"`
+	Country string `json:"country,omitempty"`
+	Center  LatLng `json:"center"`
+}
+
+// Geocoder turns location text and coordinates into canonical locations.
+// PlacesClient implements it with the Geocoding API.
+type Geocoder interface {
+	Geocode(ctx context.Context, address string) (*CanonicalLocation, error)
+	ReverseGeocode(ctx context.Context, point LatLng) (*CanonicalLocation, error)
+}
+
+// reverseGeocodeTypes limits reverse geocoding to cities, so a coordinate
+// resolves to the same key as the name of the city it is in.
+var reverseGeocodeTypes = []string{"locality"}
+
+// Geocode resolves free-text location to its canonical location.
+func (pc *PlacesClient) Geocode(ctx context.Context, address string) (*CanonicalLocation, error) {
+	results, err := pc.geocode(ctx, &maps.GeocodingRequest{Address: address})
+	if err != nil {
+		return nil, fmt.Errorf("error geocoding %q: %w", address, err)
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("%q: %w", address, ErrLocationNotFound)
+	}
+	return canonicalFromGeocode(results[0]), nil
+}
+
+// ReverseGeocode resolves point to the city it lies in. Outside city limits
+// there is none, and point resolves to the most specific address found
+// instead.
+func (pc *PlacesClient) ReverseGeocode(ctx context.Context, point LatLng) (*CanonicalLocation, error) {
+	latLng := &maps.LatLng{Lat: point.Lat, Lng: point.Lng}
+	results, err := pc.geocode(ctx, &maps.GeocodingRequest{LatLng: latLng, ResultType: reverseGeocodeTypes})
+	if err == nil && len(results) == 0 {
+		results, err = pc.geocode(ctx, &maps.GeocodingRequest{LatLng: latLng})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reverse geocoding %s: %w", point, err)
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("%s: %w", point, ErrLocationNotFound)
+	}
+	return canonicalFromGeocode(results[0]), nil
+}
+
+// geocode runs a geocoding request once the rate limit allows it, retrying
+// transient failures.
+func (pc *PlacesClient) geocode(ctx context.Context, r *maps.GeocodingRequest) ([]maps.GeocodingResult, error) {
+	var results []maps.GeocodingResult
+	err := pc.retry.Do(ctx, func() error {
+		if err := pc.wait(ctx); err != nil {
+			return err
+		}
+
+		var err error
+		results, err = pc.Client.Geocode(ctx, r)
+		return err
+	})
+	return results, err
+}
+
+func canonicalFromGeocode(result maps.GeocodingResult) *CanonicalLocation {
+	location := &CanonicalLocation{
+		PlaceID: result.PlaceID,
+		Name:    result.FormattedAddress,
+		Center:  LatLng{Lat: result.Geometry.Location.Lat, Lng: result.Geometry.Location.Lng},
+	}
+	for _, component := range result.AddressComponents {
+		for _, t := range component.Types {
+			switch t {
+			case "locality":
+				location.City = component.LongName
+			case "administrative_area_level_1":
+				location.State = component.ShortName
+			case "country":
+				location.Country = component.ShortName
+			}
+		}
+	}
+	location.Key = canonicalKey(location)
+	return location
+}
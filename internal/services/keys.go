@@ -0,0 +1,253 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Object names are built only by the functions in this file. The bucket
+// layout is versioned by its first path segment; the current one is:
+//
+//	v2/aliases/{alias}.json
+//	v2/api-keys/{api key}.json
+//	v2/accounts/{account}/locations/{location}/location.json
+//	v2/accounts/{account}/locations/{location}/snapshots/{version}/competitors/{competitor}/competitor.json
+//	v2/accounts/{account}/locations/{location}/snapshots/{version}/competitors/{competitor}/products/{category}/{product}.json
+//	v2/accounts/{account}/files/{file}
+//	v2/sites/{site}/map.json
+//	v2/sites/{site}/pages/{page}.json
+//
+// {alias} and {location} are slugs (see LocationResolver), {api key} is the
+// hex ID of an API key, {version} is a snapshot timestamp, {category} is a
+// category slug and {account}, {competitor}, {product}, {site} and {page} are
+// stable IDs built by accountID, competitorID, productID, siteID and pageID.
+// Every segment is made of [a-z0-9-] only, except in {file}, which is a
+// name chosen by the caller and checked by the server (see FileObjectName).
+//
+// Locations are stored per account so customers can't read each other's
+// competitor sets; aliases only hold geocoding results and sites only hold
+// what competitors publish on their websites, so both are shared. Data
+// written without an account, such as with authentication disabled, omits
+// the accounts/{account}/ segments; cmd/migrate-storage -user or -tenant
+// hands it over to an account.
+//
+// The unversioned layout used before v2 put raw names in the path:
+//
+//	aliases/{alias}.json
+//	{location}/location.json
+//	{location}/snapshots/{version}/{competitor name}/competitor
+//	{location}/snapshots/{version}/{competitor name}/{category}/{product name}.json
+//
+// cmd/migrate-storage rewrites it into the current layout.
+const LayoutVersion = "v2"
+
+// maxSlugLength keeps object names well below the 1024 byte limit of GCS.
+const maxSlugLength = 60
+
+// idHashLength is the number of hex characters of the hash in stable IDs.
+const idHashLength = 10
+
+// uncategorized is the category segment of products without a category.
+const uncategorized = "uncategorized"
+
+func aliasObjectName(alias string) string {
+	return fmt.Sprintf("%s/aliases/%s.json", LayoutVersion, alias)
+}
+
+func apiKeysPrefix() string {
+	return LayoutVersion + "/api-keys/"
+}
+
+func apiKeyObjectName(id string) string {
+	return apiKeysPrefix() + id + ".json"
+}
+
+// FileObjectName is the object an API caller's file name is stored in:
+// below the account ctx carries, so customers can't read or overwrite each
+// other's files, or name itself when ctx carries no account.
+func FileObjectName(ctx context.Context, name string) string {
+	scope := accountScope(ctx)
+	if scope == "" {
+		return name
+	}
+	return fmt.Sprintf("%s/accounts/%s/files/%s", LayoutVersion, scope, name)
+}
+
+func sitePrefix(website string) string {
+	return fmt.Sprintf("%s/sites/%s", LayoutVersion, siteID(website))
+}
+
+func siteMapObjectName(website string) string {
+	return sitePrefix(website) + "/map.json"
+}
+
+func pageObjectName(website, pageURL string) string {
+	return fmt.Sprintf("%s/pages/%s.json", sitePrefix(website), pageID(pageURL))
+}
+
+func locationPrefix(scope, locationKey string) string {
+	if scope == "" {
+		return fmt.Sprintf("%s/locations/%s", LayoutVersion, locationKey)
+	}
+	return fmt.Sprintf("%s/accounts/%s/locations/%s", LayoutVersion, scope, locationKey)
+}
+
+func locationObjectName(scope, locationKey string) string {
+	return locationPrefix(scope, locationKey) + "/location.json"
+}
+
+func snapshotsPrefix(scope, locationKey string) string {
+	return locationPrefix(scope, locationKey) + "/snapshots/"
+}
+
+func snapshotPrefix(scope, locationKey, version string) string {
+	return snapshotsPrefix(scope, locationKey) + version
+}
+
+func competitorPrefix(scope, locationKey, version string, competitor Competitor) string {
+	return fmt.Sprintf("%s/competitors/%s", snapshotPrefix(scope, locationKey, version), competitorID(competitor))
+}
+
+func competitorObjectName(scope, locationKey, version string, competitor Competitor) string {
+	return competitorPrefix(scope, locationKey, version, competitor) + "/competitor.json"
+}
+
+func productObjectName(scope, locationKey, version string, competitor Competitor, product Product) string {
+	category := slugify(product.Category)
+	if category == "" {
+		category = uncategorized
+	}
+	return fmt.Sprintf("%s/products/%s/%s.json", competitorPrefix(scope, locationKey, version, competitor), category, productID(product))
+}
+
+// canonicalKey is "<city>-<state>-<country>" for cities, such as
+// "austin-tx-us", and "place-<hash of the place ID>" for anything else.
+// Place IDs are case-sensitive, so like Firebase IDs in accountID they are
+// hashed rather than slugged.
+func canonicalKey(location *CanonicalLocation) string {
+	if location.City != "" {
+		return slugify(strings.Join([]string{location.City, location.State, location.Country}, " "))
+	}
+	return "place-" + longHash("place:"+location.PlaceID)
+}
+
+// competitorID identifies a competitor by the slug of its name followed by a
+// hash of its Place ID, or of its website when it has none. Two businesses
+// with the same name get different IDs, and a renamed one keeps its hash.
+func competitorID(competitor Competitor) string {
+	identity := "place:" + competitor.PlaceID
+	if competitor.PlaceID == "" {
+		identity = "site:" + normalizeWebsite(competitor.Website)
+		if competitor.Website == "" {
+			identity = "name:" + normalizeName(competitor.Name)
+		}
+	}
+	return stableID(competitor.Name, identity)
+}
+
+// accountID identifies an account by a hash of its tenant, or of its user
+// when it has none. Firebase IDs are case-sensitive so they can't be slugged,
+// and the hash is longer than idHashLength because a collision would share
+// data between customers.
+func accountID(account Account) string {
+	identity := "user:" + account.UserID
+	if account.TenantID != "" {
+		identity = "tenant:" + account.TenantID
+	}
+	return longHash(identity)
+}
+
+// longHash hashes identities whose collision would mix up the data of
+// different customers or places.
+func longHash(identity string) string {
+	sum := sha256.Sum256([]byte(identity))
+	return hex.EncodeToString(sum[:16])
+}
+
+// productID identifies a product by the slug of its name followed by a hash
+// of the name, so names differing only in punctuation don't collide. Names
+// differing only in case or spacing are the same product, as in
+// dedupeProducts.
+func productID(product Product) string {
+	return stableID(product.Name, "name:"+normalizeName(product.Name))
+}
+
+// siteID identifies a website by the slug of its domain followed by a hash of
+// it, so every page and form of a site's URL shares one cache.
+func siteID(website string) string {
+	domain, _, _ := strings.Cut(normalizeWebsite(website), "/")
+	return stableID(domain, "domain:"+domain)
+}
+
+// pageID identifies a page by the slug of its path followed by a hash of the
+// whole URL, query included, since listing pages are often paginated by it.
+func pageID(pageURL string) string {
+	path := pageURL
+	if u, err := url.Parse(strings.TrimSpace(pageURL)); err == nil {
+		path = u.Path
+	}
+	return stableID(path, "page:"+strings.TrimSpace(pageURL))
+}
+
+func stableID(name, identity string) string {
+	sum := sha256.Sum256([]byte(identity))
+	hash := hex.EncodeToString(sum[:])[:idHashLength]
+
+	slug := slugify(name)
+	if len(slug) > maxSlugLength {
+		slug = strings.TrimRight(slug[:maxSlugLength], "-")
+	}
+	if slug == "" {
+		return hash
+	}
+	return slug + "-" + hash
+}
+
+// normalizeName lowercases name and collapses its whitespace.
+func normalizeName(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
+
+// normalizeWebsite reduces a website to its host without "www." and its path
+// without trailing slash, so the forms of one site share an identity.
+func normalizeWebsite(website string) string {
+	website = strings.TrimSpace(website)
+	u, err := url.Parse(website)
+	if err != nil || u.Host == "" {
+		u, err = url.Parse("https://" + website)
+		if err != nil {
+			return strings.ToLower(website)
+		}
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	return host + strings.TrimRight(u.EscapedPath(), "/")
+}
+
+// slugify lowercases s, strips accents and replaces every run of characters
+// other than ASCII letters and digits with a single "-".
+func slugify(s string) string {
+	var b strings.Builder
+	pendingDash := false
+	for _, r := range norm.NFKD.String(s) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// combining accent left over from decomposition
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if pendingDash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			pendingDash = false
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			pendingDash = true
+		}
+	}
+	return b.String()
+}
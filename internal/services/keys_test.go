@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"Austin, TX":           "austin-tx",
+		"  austin   tx ":       "austin-tx",
+		"São Paulo":            "sao-paulo",
+		"Winston-Salem/NC":     "winston-salem-nc",
+		"ChIJLwPMoJm1RIYRetVp": "chijlwpmojm1riyretvp",
+		"///":                  "",
+	}
+	for in, want := range tests {
+		if got := slugify(in); got != want {
+			t.Errorf("slugify(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCanonicalKey(t *testing.T) {
+	city := &CanonicalLocation{PlaceID: "abc", City: "Austin", State: "TX", Country: "US"}
+	if got := canonicalKey(city); got != "austin-tx-us" {
+		t.Errorf("city key = %q, want austin-tx-us", got)
+	}
+	region := canonicalKey(&CanonicalLocation{PlaceID: "ChIJ_Region"})
+	if !regexp.MustCompile(`^place-[0-9a-f]{32}$`).MatchString(region) {
+		t.Errorf("region key = %q, want place-<hash>", region)
+	}
+	for _, other := range []string{"chij_region", "ChIJ-Region"} {
+		if canonicalKey(&CanonicalLocation{PlaceID: other}) == region {
+			t.Errorf("place IDs ChIJ_Region and %s share key %s", other, region)
+		}
+	}
+}
+
+func TestCompetitorID(t *testing.T) {
+	a := Competitor{PlaceID: "p1", Name: "Party Rentals", Website: "https://a.example"}
+	b := Competitor{PlaceID: "p2", Name: "Party Rentals", Website: "https://b.example"}
+	if competitorID(a) == competitorID(b) {
+		t.Errorf("competitors with the same name share ID %s", competitorID(a))
+	}
+	if !strings.HasPrefix(competitorID(a), "party-rentals-") {
+		t.Errorf("competitorID = %q, want the name slug first", competitorID(a))
+	}
+
+	renamed := a
+	renamed.Name = "Party Rentals LLC"
+	if !strings.HasSuffix(competitorID(renamed), strings.TrimPrefix(competitorID(a), "party-rentals")) {
+		t.Errorf("renaming changed the hash: %s vs %s", competitorID(renamed), competitorID(a))
+	}
+
+	// Without a Place ID the forms of a website share an identity
+	c := Competitor{Name: "Jumpers", Website: "https://www.Jumpers.example/"}
+	d := Competitor{Name: "Jumpers", Website: "jumpers.example"}
+	if competitorID(c) != competitorID(d) {
+		t.Errorf("competitorID differs by website form: %s vs %s", competitorID(c), competitorID(d))
+	}
+}
+
+func TestProductID(t *testing.T) {
+	if productID(Product{Name: "Castle  Bounce"}) != productID(Product{Name: "castle bounce"}) {
+		t.Error("productID differs by case and spacing")
+	}
+	if productID(Product{Name: "Castle!"}) == productID(Product{Name: "Castle?"}) {
+		t.Error("productID collides for names differing in punctuation")
+	}
+	long := productID(Product{Name: strings.Repeat("very long name ", 20)})
+	if len(long) > maxSlugLength+1+idHashLength {
+		t.Errorf("productID is %d characters long", len(long))
+	}
+	if id := productID(Product{Name: "★★★"}); len(id) != idHashLength {
+		t.Errorf("productID of a name without letters = %q, want the hash only", id)
+	}
+}
+
+func TestAccountID(t *testing.T) {
+	if accountID(Account{UserID: "abcDEF"}) == accountID(Account{UserID: "ABCdef"}) {
+		t.Error("accountID collides for user IDs differing in case")
+	}
+	if accountID(Account{UserID: "a", TenantID: "acme"}) != accountID(Account{UserID: "b", TenantID: "acme"}) {
+		t.Error("users of one tenant have different account IDs")
+	}
+	if accountID(Account{UserID: "acme"}) == accountID(Account{UserID: "x", TenantID: "acme"}) {
+		t.Error("a user ID collides with a tenant ID")
+	}
+}
+
+func TestSiteID(t *testing.T) {
+	if siteID("https://www.Jumpers.example/") != siteID("jumpers.example/rentals?x=1") {
+		t.Error("siteID differs between pages and forms of one domain")
+	}
+	if !strings.HasPrefix(siteID("https://jumpers.example"), "jumpers-example-") {
+		t.Errorf("siteID = %q, want the domain slug first", siteID("https://jumpers.example"))
+	}
+	if pageID("https://a.example/rentals?page=1") == pageID("https://a.example/rentals?page=2") {
+		t.Error("pageID collides for pages differing in query")
+	}
+}
+
+func TestFileObjectName(t *testing.T) {
+	ctx := context.Background()
+	if got := FileObjectName(ctx, "uploads/a.csv"); got != "uploads/a.csv" {
+		t.Errorf("FileObjectName without account = %q, want the name itself", got)
+	}
+	alice := FileObjectName(ContextWithAccount(ctx, Account{UserID: "alice"}), "uploads/a.csv")
+	bob := FileObjectName(ContextWithAccount(ctx, Account{UserID: "bob"}), "uploads/a.csv")
+	if alice == bob || !strings.HasPrefix(alice, LayoutVersion+"/accounts/") || !strings.HasSuffix(alice, "/files/uploads/a.csv") {
+		t.Errorf("FileObjectName = %q and %q, want distinct names below each account", alice, bob)
+	}
+}
+
+func TestObjectNamesAreSafe(t *testing.T) {
+	segment := regexp.MustCompile(`^[a-z0-9-]+(\.json)?$`)
+	competitor := Competitor{Name: "Jump / Slide Co. ", Website: "https://jump.example"}
+	product := Product{Name: "Château 15'x15' ", Category: "Bounce/House"}
+
+	names := []string{
+		aliasObjectName("austin-tx"),
+		locationObjectName("", "austin-tx-us"),
+		competitorObjectName("", "austin-tx-us", "20240101T000000.000000000Z", competitor),
+		productObjectName("", "austin-tx-us", "20240101T000000.000000000Z", competitor, product),
+		productObjectName("", "austin-tx-us", "v1", competitor, Product{Name: "Tent"}),
+		locationObjectName(accountID(Account{UserID: "Xy9/Uid_"}), "austin-tx-us"),
+		siteMapObjectName("https://www.Jump.example/home"),
+		pageObjectName("https://jump.example", "https://jump.example/Rentals/?page=2&sort=price"),
+	}
+	for _, name := range names {
+		if !strings.HasPrefix(name, LayoutVersion+"/") {
+			t.Errorf("%s is outside the %s layout", name, LayoutVersion)
+		}
+		for _, part := range strings.Split(name, "/") {
+			if part == "20240101T000000.000000000Z" {
+				continue
+			}
+			if !segment.MatchString(part) {
+				t.Errorf("%s has unsafe segment %q", name, part)
+			}
+		}
+	}
+	if !strings.HasSuffix(names[2], "/competitor.json") {
+		t.Errorf("competitor object %s lacks .json", names[2])
+	}
+	if !strings.Contains(names[4], "/products/uncategorized/") {
+		t.Errorf("uncategorized product stored at %s", names[4])
+	}
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// LocalStore is a Store backed by a directory tree, intended for local
+// development without GCP credentials.
+type LocalStore struct {
+	*objectStore
+	root string
+}
+
+func NewLocalStore(root string, logger *log.Logger) (*LocalStore, error) {
+	if root == "" {
+		return nil, fmt.Errorf("local storage directory is required")
+	}
+
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving local storage directory: %v", err)
+	}
+	if err := os.MkdirAll(absRoot, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating local storage directory: %v", err)
+	}
+
+	return &LocalStore{
+		objectStore: newObjectStore(localBackend{root: absRoot, mu: new(sync.Mutex)}, noRetry, logger),
+		root:        absRoot,
+	}, nil
+}
+
+// localBackend maps object names onto files below root. Files carry no
+// generation, so conditional writes compare a hash of the content instead and
+// are only atomic within the process, which mu serializes them in.
+type localBackend struct {
+	root string
+	mu   *sync.Mutex
+}
+
+// path resolves an object name to a file path, refusing names that would
+// escape root.
+func (b localBackend) path(name string) (string, error) {
+	p := filepath.Join(b.root, filepath.FromSlash(name))
+	if p != b.root && !strings.HasPrefix(p, b.root+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid object name %q", name)
+	}
+	return p, nil
+}
+
+// newWriter ignores contentType; stat derives it from the file extension.
+func (b localBackend) newWriter(ctx context.Context, name, contentType string) io.WriteCloser {
+	p, err := b.path(name)
+	if err != nil {
+		return &localWriter{err: err}
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return &localWriter{err: err}
+	}
+
+	// Write to a temporary file and rename on Close so readers never see a
+	// partially written object.
+	f, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return &localWriter{err: err}
+	}
+	return &localWriter{ctx: ctx, f: f, dest: p, mu: b.mu}
+}
+
+func (b localBackend) newWriterIf(ctx context.Context, name, contentType string, generation int64) io.WriteCloser {
+	w := b.newWriter(ctx, name, contentType).(*localWriter)
+	w.precondition = func() error {
+		_, current, err := b.readGeneration(ctx, name)
+		if errors.Is(err, ErrObjectNotFound) {
+			current, err = 0, nil
+		}
+		if err != nil {
+			return err
+		}
+		if current != generation {
+			return fmt.Errorf("%s: %w", name, errGenerationMismatch)
+		}
+		return nil
+	}
+	return w
+}
+
+func (b localBackend) readGeneration(ctx context.Context, name string) ([]byte, int64, error) {
+	rc, err := b.newReader(ctx, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, 0, err
+	}
+	sum := sha256.Sum256(data)
+	// Generation 0 is reserved for missing objects
+	return data, int64(binary.BigEndian.Uint64(sum[:]) | 1), nil
+}
+
+func (b localBackend) newReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	p, err := b.path(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", name, ErrObjectNotFound)
+	}
+	return f, err
+}
+
+func (b localBackend) newRangeReader(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	rc, err := b.newReader(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	f := rc.(*os.File)
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+func (b localBackend) stat(ctx context.Context, name string) (ObjectAttrs, error) {
+	p, err := b.path(name)
+	if err != nil {
+		return ObjectAttrs{}, err
+	}
+	info, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return ObjectAttrs{}, fmt.Errorf("%s: %w", name, ErrObjectNotFound)
+	}
+	if err != nil {
+		return ObjectAttrs{}, err
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return ObjectAttrs{
+		Name:        name,
+		Size:        info.Size(),
+		ContentType: contentType,
+		Updated:     info.ModTime(),
+	}, nil
+}
+
+func (b localBackend) list(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(b.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(b.root, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	return names, err
+}
+
+func (b localBackend) delete(ctx context.Context, name string) error {
+	p, err := b.path(name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s: %w", name, ErrObjectNotFound)
+	}
+	return err
+}
+
+type localWriter struct {
+	ctx  context.Context
+	f    *os.File
+	dest string
+	err  error
+	// mu serializes renames so precondition still holds when dest is
+	// replaced.
+	mu           *sync.Mutex
+	precondition func() error
+}
+
+func (w *localWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.f.Write(p)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+func (w *localWriter) Close() error {
+	if w.f == nil {
+		return w.err
+	}
+	closeErr := w.f.Close()
+	if w.err == nil {
+		w.err = closeErr
+	}
+	if w.err == nil {
+		w.err = w.ctx.Err()
+	}
+	if w.err != nil {
+		os.Remove(w.f.Name())
+		return w.err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.precondition != nil {
+		if err := w.precondition(); err != nil {
+			os.Remove(w.f.Name())
+			return err
+		}
+	}
+	return os.Rename(w.f.Name(), w.dest)
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+)
+
+// LocationResolver maps the locations callers give onto canonical locations,
+// so that "Austin, TX", "austin tx" and "Austin Texas" all read and write the
+// same data. Resolved aliases are kept in the store and only geocoded once.
+type LocationResolver struct {
+	geocoder Geocoder
+	store    Store
+	logger   *log.Logger
+
+	mu      sync.RWMutex
+	aliases map[string]*CanonicalLocation
+}
+
+func NewLocationResolver(geocoder Geocoder, store Store, logger *log.Logger) *LocationResolver {
+	return &LocationResolver{
+		geocoder: geocoder,
+		store:    store,
+		logger:   logger,
+		aliases:  make(map[string]*CanonicalLocation),
+	}
+}
+
+// Resolve returns the canonical location for name. Names differing only in
+// case, accents or punctuation share an alias and are geocoded once.
+func (lr *LocationResolver) Resolve(ctx context.Context, name string) (*CanonicalLocation, error) {
+	alias := slugify(name)
+	if alias == "" {
+		return nil, fmt.Errorf("location %q: %w", name, ErrLocationNotFound)
+	}
+	return lr.resolveAlias(ctx, alias, func() (*CanonicalLocation, error) {
+		return lr.geocoder.Geocode(ctx, name)
+	})
+}
+
+// aliasCellsPerDegree sets the precision of center aliases: a hundredth of
+// a degree, about a kilometer. Centers within the same cell share an alias,
+// so nearby searches don't each store one and pay for a reverse geocode.
+const aliasCellsPerDegree = 100
+
+// ResolveArea returns the canonical location of area: its location text when
+// given, otherwise the city its center lies in. The center is snapped to
+// its alias cell before it is reverse geocoded.
+func (lr *LocationResolver) ResolveArea(ctx context.Context, area SearchArea) (*CanonicalLocation, error) {
+	if area.Location != "" || area.Center == nil {
+		return lr.Resolve(ctx, area.Location)
+	}
+
+	center := LatLng{
+		Lat: math.Round(area.Center.Lat*aliasCellsPerDegree) / aliasCellsPerDegree,
+		Lng: math.Round(area.Center.Lng*aliasCellsPerDegree) / aliasCellsPerDegree,
+	}
+	return lr.resolveAlias(ctx, "latlng-"+slugify(center.String()), func() (*CanonicalLocation, error) {
+		return lr.geocoder.ReverseGeocode(ctx, center)
+	})
+}
+
+// resolveAlias looks alias up in memory, then in the store, and finally
+// calls geocode and records the answer in both.
+func (lr *LocationResolver) resolveAlias(ctx context.Context, alias string, geocode func() (*CanonicalLocation, error)) (*CanonicalLocation, error) {
+	lr.mu.RLock()
+	location, ok := lr.aliases[alias]
+	lr.mu.RUnlock()
+	if ok {
+		return location, nil
+	}
+
+	location, err := lr.store.GetAlias(ctx, alias)
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		lr.logger.Printf("Error reading location alias %s: %v", alias, err)
+	}
+	if err != nil {
+		if location, err = geocode(); err != nil {
+			return nil, err
+		}
+		if err := lr.store.StoreAlias(ctx, alias, *location); err != nil {
+			lr.logger.Printf("Error storing location alias %s: %v", alias, err)
+		}
+		lr.logger.Printf("Location alias %s resolved to %s", alias, location.Key)
+	}
+
+	lr.mu.Lock()
+	lr.aliases[alias] = location
+	lr.mu.Unlock()
+	return location, nil
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// stubGeocoder resolves addresses from a fixed table and counts its calls.
+type stubGeocoder struct {
+	mu        sync.Mutex
+	addresses map[string]*CanonicalLocation
+	calls     int
+}
+
+func (g *stubGeocoder) Geocode(ctx context.Context, address string) (*CanonicalLocation, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.calls++
+	if location, ok := g.addresses[strings.ToLower(address)]; ok {
+		return location, nil
+	}
+	return nil, ErrLocationNotFound
+}
+
+func (g *stubGeocoder) ReverseGeocode(ctx context.Context, point LatLng) (*CanonicalLocation, error) {
+	return g.Geocode(ctx, "austin")
+}
+
+func TestLocationResolverAliases(t *testing.T) {
+	austin := &CanonicalLocation{Key: "austin-tx-us", Name: "Austin, TX, USA"}
+	geocoder := &stubGeocoder{addresses: map[string]*CanonicalLocation{
+		"austin, tx":   austin,
+		"austin texas": austin,
+		"austin":       austin,
+	}}
+	store := NewMemoryStore(testLogger())
+	resolver := NewLocationResolver(geocoder, store, testLogger())
+	ctx := context.Background()
+
+	for _, name := range []string{"Austin, TX", "austin tx", "AUSTIN  TX.", "Austin Texas"} {
+		got, err := resolver.Resolve(ctx, name)
+		if err != nil {
+			t.Fatalf("Resolve(%q): %v", name, err)
+		}
+		if got.Key != "austin-tx-us" {
+			t.Errorf("Resolve(%q) key = %q, want austin-tx-us", name, got.Key)
+		}
+	}
+	// "austin tx" and "AUSTIN  TX." share the slug of "Austin, TX"
+	if geocoder.calls != 2 {
+		t.Errorf("geocoded %d times, want 2", geocoder.calls)
+	}
+
+	// A new resolver finds the aliases in the store
+	resolver = NewLocationResolver(geocoder, store, testLogger())
+	if _, err := resolver.Resolve(ctx, "Austin Texas"); err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if geocoder.calls != 2 {
+		t.Errorf("geocoded %d times after restart, want 2", geocoder.calls)
+	}
+
+	if _, err := resolver.Resolve(ctx, "Atlantis"); !errors.Is(err, ErrLocationNotFound) {
+		t.Errorf("Resolve(Atlantis) error = %v, want ErrLocationNotFound", err)
+	}
+	if _, err := resolver.Resolve(ctx, " / "); !errors.Is(err, ErrLocationNotFound) {
+		t.Errorf("Resolve of punctuation error = %v, want ErrLocationNotFound", err)
+	}
+
+	calls := geocoder.calls
+	for _, center := range []LatLng{{Lat: 30.27, Lng: -97.74}, {Lat: 30.2712, Lng: -97.7436}} {
+		got, err := resolver.ResolveArea(ctx, SearchArea{Center: &center})
+		if err != nil || got.Key != "austin-tx-us" {
+			t.Errorf("ResolveArea(%s) = %+v, %v, want austin-tx-us", center, got, err)
+		}
+	}
+	// Both centers round to the same alias
+	if geocoder.calls != calls+1 {
+		t.Errorf("reverse geocoded %d times, want 1", geocoder.calls-calls)
+	}
+}
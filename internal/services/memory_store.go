@@ -0,0 +1,155 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store that keeps every object in memory. Data is lost
+// when the process exits.
+type MemoryStore struct {
+	*objectStore
+}
+
+func NewMemoryStore(logger *log.Logger) *MemoryStore {
+	backend := &memoryBackend{objects: make(map[string]memoryObject)}
+	return &MemoryStore{
+		objectStore: newObjectStore(backend, noRetry, logger),
+	}
+}
+
+type memoryBackend struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+	// generations counts the writes, numbering the generations of objects.
+	generations int64
+}
+
+type memoryObject struct {
+	data        []byte
+	contentType string
+	updated     time.Time
+	generation  int64
+}
+
+func (b *memoryBackend) newWriter(ctx context.Context, name, contentType string) io.WriteCloser {
+	return &memoryWriter{ctx: ctx, backend: b, name: name, contentType: contentType}
+}
+
+func (b *memoryBackend) newWriterIf(ctx context.Context, name, contentType string, generation int64) io.WriteCloser {
+	return &memoryWriter{ctx: ctx, backend: b, name: name, contentType: contentType, conditional: true, generation: generation}
+}
+
+func (b *memoryBackend) readGeneration(ctx context.Context, name string) ([]byte, int64, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	object, ok := b.objects[name]
+	if !ok {
+		return nil, 0, fmt.Errorf("%s: %w", name, ErrObjectNotFound)
+	}
+	return bytes.Clone(object.data), object.generation, nil
+}
+
+func (b *memoryBackend) newReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.newRangeReader(ctx, name, 0, -1)
+}
+
+func (b *memoryBackend) newRangeReader(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	object, ok := b.objects[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, ErrObjectNotFound)
+	}
+	data := object.data[min(offset, int64(len(object.data))):]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (b *memoryBackend) stat(ctx context.Context, name string) (ObjectAttrs, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	object, ok := b.objects[name]
+	if !ok {
+		return ObjectAttrs{}, fmt.Errorf("%s: %w", name, ErrObjectNotFound)
+	}
+	return ObjectAttrs{
+		Name:        name,
+		Size:        int64(len(object.data)),
+		ContentType: object.contentType,
+		Updated:     object.updated,
+	}, nil
+}
+
+func (b *memoryBackend) list(ctx context.Context, prefix string) ([]string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var names []string
+	for name := range b.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (b *memoryBackend) delete(ctx context.Context, name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.objects[name]; !ok {
+		return fmt.Errorf("%s: %w", name, ErrObjectNotFound)
+	}
+	delete(b.objects, name)
+	return nil
+}
+
+type memoryWriter struct {
+	ctx         context.Context
+	backend     *memoryBackend
+	name        string
+	contentType string
+	buf         bytes.Buffer
+	// conditional writes only replace the object at generation.
+	conditional bool
+	generation  int64
+}
+
+func (w *memoryWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *memoryWriter) Close() error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+
+	w.backend.mu.Lock()
+	defer w.backend.mu.Unlock()
+
+	if w.conditional && w.backend.objects[w.name].generation != w.generation {
+		return fmt.Errorf("%s: %w", w.name, errGenerationMismatch)
+	}
+	w.backend.generations++
+	w.backend.objects[w.name] = memoryObject{
+		data:        bytes.Clone(w.buf.Bytes()),
+		contentType: w.contentType,
+		updated:     time.Now(),
+		generation:  w.backend.generations,
+	}
+	return nil
+}
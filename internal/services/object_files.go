@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+)
+
+// UploadFile copies a local file into objectName. It reads the filesystem of
+// the calling process, so it's only meant for command line tools and never
+// for paths given by API callers.
+func UploadFile(ctx context.Context, store Store, filePath, objectName string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("error opening file: %v", err)
+	}
+	defer f.Close()
+
+	contentType := mime.TypeByExtension(filepath.Ext(filePath))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return store.PutObject(ctx, objectName, contentType, f)
+}
+
+// DownloadFile copies objectName into a local file, with the same caveat as
+// UploadFile. The file is only created once the object is known to exist.
+func DownloadFile(ctx context.Context, store Store, objectName, destPath string) error {
+	rc, err := store.OpenObject(ctx, objectName, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	f, err := os.Create(destPath)
+	if err != nil {
+		return fmt.Errorf("error creating file: %v", err)
+	}
+	if _, err := io.Copy(f, rc); err != nil {
+		f.Close()
+		return fmt.Errorf("error downloading file from storage: %w", err)
+	}
+	return f.Close()
+}
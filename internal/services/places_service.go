@@ -3,81 +3,296 @@ package services
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"googlemaps.github.io/maps"
 )
 
+// competitorQueries are the phrasings searched for; competitors list
+// themselves under any of them.
+var competitorQueries = []string{
+	"bounce house rentals",
+	"party rentals",
+	"inflatable rentals",
+	"moonwalk rentals",
+}
+
+// Bounds of SearchArea.RadiusMeters. Places doesn't accept more than 50km.
+const (
+	defaultSearchRadius = 25000
+	maxSearchRadius     = 50000
+)
+
+// SearchArea is where to look for competitors: a free-text location, a
+// center with a radius, or both, in which case the text is searched with
+// results biased to the circle.
+type SearchArea struct {
+	Location string  `json:"location,omitempty"`
+	Center   *LatLng `json:"center,omitempty"`
+	// RadiusMeters applies to Center and defaults to 25km.
+	RadiusMeters uint `json:"radiusMeters,omitempty"`
+}
+
+// Validate reports the first problem with the area.
+func (a SearchArea) Validate() error {
+	if strings.TrimSpace(a.Location) == "" && a.Center == nil {
+		return fmt.Errorf("a location or a center coordinate is required")
+	}
+	if a.Center != nil {
+		if err := a.Center.Validate(); err != nil {
+			return err
+		}
+	} else if a.RadiusMeters != 0 {
+		return fmt.Errorf("radius requires a center coordinate")
+	}
+	if a.RadiusMeters > maxSearchRadius {
+		return fmt.Errorf("radius cannot exceed %d meters", maxSearchRadius)
+	}
+	return nil
+}
+
+// Name identifies the area in stored results: the location text, or the
+// center when searching by coordinate only.
+func (a SearchArea) Name() string {
+	if a.Location != "" || a.Center == nil {
+		return a.Location
+	}
+	return a.Center.String()
+}
+
+func (a SearchArea) String() string {
+	if a.Center == nil {
+		return a.Location
+	}
+	radius := a.radius()
+	if a.Location == "" {
+		return fmt.Sprintf("%dm around %s", radius, a.Center)
+	}
+	return fmt.Sprintf("%s (%dm around %s)", a.Location, radius, a.Center)
+}
+
+// textSearchRequest builds the first-page request for query within a.
+func (a SearchArea) textSearchRequest(query string) *maps.TextSearchRequest {
+	request := &maps.TextSearchRequest{Query: query}
+	if a.Location != "" {
+		request.Query += " in " + a.Location
+	}
+	if a.Center != nil {
+		request.Location = &maps.LatLng{Lat: a.Center.Lat, Lng: a.Center.Lng}
+		request.Radius = a.RadiusMeters
+		if request.Radius == 0 {
+			request.Radius = defaultSearchRadius
+		}
+	}
+	return request
+}
+
+// A next_page_token only becomes valid a short while after it is issued;
+// requesting it earlier fails with INVALID_REQUEST.
+const (
+	defaultPageTokenDelay = 2 * time.Second
+	pageTokenAttempts     = 3
+)
+
+// PlacesClient discovers competitors through the Google Places API.
 type PlacesClient struct {
-	Client *maps.Client
+	Client  *maps.Client
+	limiter *RateLimiter
+	retry   RetryPolicy
+
+	// pageTokenDelay is how long to wait before requesting the next page.
+	pageTokenDelay time.Duration
 }
 
-type CompetitorResult struct {
-	Name    string
-	Website string
-	PlaceID string
+// Place is a business found through Places. Website, Phone and Hours are
+// only set once the place has been through Details.
+type Place struct {
+	PlaceID     string   `json:"placeId"`
+	Name        string   `json:"name"`
+	Address     string   `json:"address,omitempty"`
+	Phone       string   `json:"phone,omitempty"`
+	Website     string   `json:"website,omitempty"`
+	Lat         float64  `json:"lat"`
+	Lng         float64  `json:"lng"`
+	Rating      float32  `json:"rating,omitempty"`
+	ReviewCount int      `json:"reviewCount,omitempty"`
+	Hours       []string `json:"hours,omitempty"`
 }
 
-func NewPlacesClient(apiKey string) (*PlacesClient, error) {
-	client, err := maps.NewClient(maps.WithAPIKey(apiKey))
+// placeDetailsFields are the fields Details requests.
+var placeDetailsFields = []maps.PlaceDetailsFieldMask{
+	maps.PlaceDetailsFieldMaskWebsite,
+	maps.PlaceDetailsFieldMaskFormattedPhoneNumber,
+	maps.PlaceDetailsFieldMaskFormattedAddress,
+	maps.PlaceDetailsFieldMaskGeometryLocation,
+	maps.PlaceDetailsFieldMaskRatings,
+	maps.PlaceDetailsFieldMaskUserRatingsTotal,
+	maps.PlaceDetailsFieldMaskOpeningHours,
+}
+
+// NewPlacesClient creates a Places client. baseURL overrides the Google Maps
+// API endpoint and is normally left empty. Requests wait on limiter when it
+// is set, and transient failures are retried according to retry.
+func NewPlacesClient(apiKey, baseURL string, limiter *RateLimiter, retry RetryPolicy) (*PlacesClient, error) {
+	options := []maps.ClientOption{maps.WithAPIKey(apiKey), maps.WithHTTPClient(newHTTPClient())}
+	if baseURL != "" {
+		options = append(options, maps.WithBaseURL(baseURL))
+	}
+	client, err := maps.NewClient(options...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Maps client: %v", err)
 	}
-	return &PlacesClient{Client: client}, nil
+	return &PlacesClient{
+		Client:         client,
+		limiter:        limiter,
+		retry:          retry,
+		pageTokenDelay: defaultPageTokenDelay,
+	}, nil
 }
 
-func (pc *PlacesClient) SearchCompetitors(ctx context.Context, location string) ([]CompetitorResult, error) {
-	var results []CompetitorResult
-	err := retry(func() error {
-		r, err := pc.Client.TextSearch(ctx, &maps.TextSearchRequest{
-			Query: "Bounce house rentals in " + location,
-		})
+// Search returns every rental business Places knows of in area. Each
+// phrasing in competitorQueries is searched through all of its result pages
+// and the results are merged, keeping the first occurrence of each place.
+func (pc *PlacesClient) Search(ctx context.Context, area SearchArea) ([]Place, error) {
+	var places []Place
+	seen := make(map[string]bool)
+	for _, query := range competitorQueries {
+		found, err := pc.searchAllPages(ctx, area.textSearchRequest(query))
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("error searching for competitors: %w", err)
 		}
-		for _, place := range r.Results {
-			details, err := pc.GetPlaceDetails(ctx, place.PlaceID)
-			if err != nil {
-				continue // Skip this competitor if we can't get details
+
+		for _, place := range found {
+			if seen[place.PlaceID] {
+				continue
 			}
-			results = append(results, CompetitorResult{
-				Name:    place.Name,
-				Website: details.Website,
-				PlaceID: place.PlaceID,
-			})
+			seen[place.PlaceID] = true
+			places = append(places, place)
 		}
-		return nil
-	})
-	return results, err
+	}
+	return places, nil
 }
 
-func (pc *PlacesClient) GetPlaceDetails(ctx context.Context, placeID string) (*maps.PlaceDetailsResult, error) {
-	var result *maps.PlaceDetailsResult
-	err := retry(func() error {
-		r, err := pc.Client.PlaceDetails(ctx, &maps.PlaceDetailsRequest{
-			PlaceID: placeID,
-			Fields:  []maps.PlaceDetailsFieldMask{maps.PlaceDetailsFieldMaskWebsite},
-		})
+// searchAllPages runs request and follows next_page_token to the last page.
+func (pc *PlacesClient) searchAllPages(ctx context.Context, request *maps.TextSearchRequest) ([]Place, error) {
+	var places []Place
+	for {
+		response, err := pc.searchPage(ctx, request)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		result = &r
-		return nil
+
+		for _, result := range response.Results {
+			places = append(places, placeFromSearchResult(result))
+		}
+
+		if response.NextPageToken == "" {
+			return places, nil
+		}
+		request = &maps.TextSearchRequest{PageToken: response.NextPageToken}
+	}
+}
+
+// searchPage runs one text search. Requests for a next page are delayed
+// until the token is valid and repeated while Places still rejects it.
+func (pc *PlacesClient) searchPage(ctx context.Context, request *maps.TextSearchRequest) (maps.PlacesSearchResponse, error) {
+	if request.PageToken == "" {
+		return pc.textSearch(ctx, request)
+	}
+
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-ctx.Done():
+			return maps.PlacesSearchResponse{}, ctx.Err()
+		case <-time.After(pc.pageTokenDelay):
+		}
+
+		response, err := pc.textSearch(ctx, request)
+		if err == nil || attempt >= pageTokenAttempts || !strings.Contains(err.Error(), "maps: INVALID_REQUEST") {
+			return response, err
+		}
+	}
+}
+
+// Details returns place with its website, phone number and opening hours
+// filled in.
+func (pc *PlacesClient) Details(ctx context.Context, place Place) (Place, error) {
+	details, err := pc.placeDetails(ctx, &maps.PlaceDetailsRequest{
+		PlaceID: place.PlaceID,
+		Fields:  placeDetailsFields,
 	})
-	return result, err
+	if err != nil {
+		return place, fmt.Errorf("error getting place details: %w", err)
+	}
+
+	place.Website = details.Website
+	place.Phone = details.FormattedPhoneNumber
+	if details.FormattedAddress != "" {
+		place.Address = details.FormattedAddress
+	}
+	if location := details.Geometry.Location; location.Lat != 0 || location.Lng != 0 {
+		place.Lat, place.Lng = location.Lat, location.Lng
+	}
+	if details.Rating != 0 {
+		place.Rating = details.Rating
+	}
+	if details.UserRatingsTotal != 0 {
+		place.ReviewCount = details.UserRatingsTotal
+	}
+	if details.OpeningHours != nil {
+		place.Hours = details.OpeningHours.WeekdayText
+	}
+	return place, nil
 }
 
-func retry(operation func() error) error {
-	maxRetries := 3
-	for i := 0; i < maxRetries; i++ {
-		err := operation()
-		if err == nil {
-			return nil
+func placeFromSearchResult(result maps.PlacesSearchResult) Place {
+	return Place{
+		PlaceID:     result.PlaceID,
+		Name:        result.Name,
+		Address:     result.FormattedAddress,
+		Lat:         result.Geometry.Location.Lat,
+		Lng:         result.Geometry.Location.Lng,
+		Rating:      result.Rating,
+		ReviewCount: result.UserRatingsTotal,
+	}
+}
+
+// textSearch runs a text search once the rate limit allows it, retrying
+// transient failures.
+func (pc *PlacesClient) textSearch(ctx context.Context, r *maps.TextSearchRequest) (maps.PlacesSearchResponse, error) {
+	var response maps.PlacesSearchResponse
+	err := pc.retry.Do(ctx, func() error {
+		if err := pc.wait(ctx); err != nil {
+			return err
 		}
-		if i == maxRetries-1 {
+
+		var err error
+		response, err = pc.Client.TextSearch(ctx, r)
+		return err
+	})
+	return response, err
+}
+
+// placeDetails fetches place details once the rate limit allows it, retrying
+// transient failures.
+func (pc *PlacesClient) placeDetails(ctx context.Context, r *maps.PlaceDetailsRequest) (maps.PlaceDetailsResult, error) {
+	var result maps.PlaceDetailsResult
+	err := pc.retry.Do(ctx, func() error {
+		if err := pc.wait(ctx); err != nil {
 			return err
 		}
-		time.Sleep(time.Duration(i+1) * time.Second)
+
+		var err error
+		result, err = pc.Client.PlaceDetails(ctx, r)
+		return err
+	})
+	return result, err
+}
+
+func (pc *PlacesClient) wait(ctx context.Context) error {
+	if pc.limiter == nil {
+		return nil
 	}
-	return fmt.Errorf("operation failed after %d retries", maxRetries)
+	return pc.limiter.Wait(ctx)
 }
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPlacesSearchMergesQueriesAndPages(t *testing.T) {
+	jumpinJacks := map[string]any{"place_id": "a", "name": "Jumpin Jacks", "formatted_address": "1 Main St", "rating": 4.5, "user_ratings_total": 120,
+		"geometry": map[string]any{"location": map[string]any{"lat": 30.1, "lng": -97.7}}}
+	bounceBros := map[string]any{"place_id": "b", "name": "Bounce Bros"}
+	partyPros := map[string]any{"place_id": "c", "name": "Party Pros"}
+
+	// Results by query, then by page token
+	results := map[string]map[string]map[string]any{
+		"bounce house rentals in Austin": {
+			"":       {"status": "OK", "results": []any{jumpinJacks}, "next_page_token": "page-2"},
+			"page-2": {"status": "OK", "results": []any{bounceBros}},
+		},
+		"party rentals in Austin":      {"": {"status": "OK", "results": []any{partyPros, jumpinJacks}}},
+		"inflatable rentals in Austin": {"": {"status": "ZERO_RESULTS"}},
+		"moonwalk rentals in Austin":   {"": {"status": "OK", "results": []any{bounceBros}}},
+	}
+
+	var requests []*http.Request
+	lastQuery := ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		if query := r.URL.Query().Get("query"); query != "" {
+			lastQuery = query
+		}
+		json.NewEncoder(w).Encode(results[lastQuery][r.URL.Query().Get("pagetoken")])
+	}))
+	defer srv.Close()
+
+	pc, err := NewPlacesClient("test-key", srv.URL, nil, RetryPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc.pageTokenDelay = 0
+
+	area := SearchArea{Location: "Austin", Center: &LatLng{Lat: 30.27, Lng: -97.74}, RadiusMeters: 5000}
+	places, err := pc.Search(context.Background(), area)
+	if err != nil {
+		t.Fatalf("Search() = %v", err)
+	}
+
+	var ids []string
+	for _, place := range places {
+		ids = append(ids, place.PlaceID)
+	}
+	if strings.Join(ids, ",") != "a,b,c" {
+		t.Fatalf("Search() found %v, want a,b,c once each", ids)
+	}
+	want := Place{PlaceID: "a", Name: "Jumpin Jacks", Address: "1 Main St", Lat: 30.1, Lng: -97.7, Rating: 4.5, ReviewCount: 120}
+	if got := places[0]; got.PlaceID != want.PlaceID || got.Address != want.Address || got.Lat != want.Lat || got.Lng != want.Lng || got.Rating != want.Rating || got.ReviewCount != want.ReviewCount {
+		t.Errorf("places[0] = %+v, want %+v", got, want)
+	}
+
+	if len(requests) != 5 {
+		t.Errorf("requests = %d, want 4 queries and 1 next page", len(requests))
+	}
+	if q := requests[0].URL.Query(); q.Get("location") != "30.27,-97.74" || q.Get("radius") != "5000" {
+		t.Errorf("first request = %s, want the search biased to the area", requests[0].URL.RawQuery)
+	}
+	if q := requests[0].URL.Query(); q.Has("type") {
+		t.Errorf("first request = %s, want no place type", requests[0].URL.RawQuery)
+	}
+}
+
+func TestReverseGeocodeOutsideCityLimits(t *testing.T) {
+	road := map[string]any{"place_id": "road", "formatted_address": "FM 1826, Texas, USA",
+		"address_components": []any{map[string]any{"long_name": "Texas", "short_name": "TX", "types": []string{"administrative_area_level_1"}}}}
+
+	var resultTypes []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resultTypes = append(resultTypes, r.URL.Query().Get("result_type"))
+		if r.URL.Query().Get("result_type") == "locality" {
+			json.NewEncoder(w).Encode(map[string]any{"status": "ZERO_RESULTS"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"status": "OK", "results": []any{road}})
+	}))
+	defer srv.Close()
+
+	pc, err := NewPlacesClient("test-key", srv.URL, nil, RetryPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	location, err := pc.ReverseGeocode(context.Background(), LatLng{Lat: 30.15, Lng: -97.95})
+	if err != nil {
+		t.Fatalf("ReverseGeocode() = %v", err)
+	}
+	if location.PlaceID != "road" || location.State != "TX" {
+		t.Errorf("ReverseGeocode() = %+v, want the first result", location)
+	}
+	if strings.Join(resultTypes, ",") != "locality," {
+		t.Errorf("result types = %q, want a locality then any result", resultTypes)
+	}
+}
+
+func TestSearchAreaValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		area    SearchArea
+		wantErr bool
+	}{
+		{"location", SearchArea{Location: "Austin"}, false},
+		{"center", SearchArea{Center: &LatLng{Lat: 30.27, Lng: -97.74}}, false},
+		{"both", SearchArea{Location: "Austin", Center: &LatLng{Lat: 30.27, Lng: -97.74}, RadiusMeters: 10000}, false},
+		{"empty", SearchArea{}, true},
+		{"blank location", SearchArea{Location: "  "}, true},
+		{"radius without center", SearchArea{Location: "Austin", RadiusMeters: 1000}, true},
+		{"radius too large", SearchArea{Center: &LatLng{}, RadiusMeters: 60000}, true},
+		{"bad latitude", SearchArea{Center: &LatLng{Lat: 95}}, true},
+	}
+
+	for _, tt := range tests {
+		if err := tt.area.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPlacesDetails(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"status": "OK",
+			"result": map[string]any{
+				"place_id":               r.URL.Query().Get("placeid"),
+				"website":                "https://jumpinjacks.example",
+				"formatted_phone_number": "(512) 555-0100",
+				"opening_hours":          map[string]any{"weekday_text": []string{"Monday: 9AM-5PM"}},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	pc, err := NewPlacesClient("test-key", srv.URL, nil, RetryPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	place, err := pc.Details(context.Background(), Place{PlaceID: "a", Name: "Jumpin Jacks", Address: "1 Main St"})
+	if err != nil {
+		t.Fatalf("Details() = %v", err)
+	}
+	if place.Website != "https://jumpinjacks.example" || place.Phone != "(512) 555-0100" || len(place.Hours) != 1 {
+		t.Errorf("Details() = %+v", place)
+	}
+	if place.Address != "1 Main St" {
+		t.Errorf("Address = %q, want the search result's address kept", place.Address)
+	}
+}
@@ -0,0 +1,267 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PriceUnit is the rental period a price applies to.
+type PriceUnit string
+
+const (
+	PriceUnitUnknown PriceUnit = ""
+	PriceUnitHour    PriceUnit = "hour"
+	PriceUnitDay     PriceUnit = "day"
+	PriceUnitWeekend PriceUnit = "weekend"
+	PriceUnitEvent   PriceUnit = "event"
+)
+
+// Price is a normalized price as shown on a competitor's site. Single prices
+// have Min == Max; ranges such as "$150-$250" keep both ends.
+type Price struct {
+	Min      float64   `json:"min"`
+	Max      float64   `json:"max"`
+	Currency string    `json:"currency,omitempty"`
+	Unit     PriceUnit `json:"unit,omitempty"`
+	Raw      string    `json:"raw,omitempty"`
+}
+
+var (
+	// priceNumber matches "1,299.00", "1299.00" and "45", and the decimal
+	// comma forms "120,50" and "1.299,00".
+	priceNumber = `(` + decimalCommaNumber + `|\d{1,3}(?:,\d{3})+(?:\.\d+)?|\d+(?:\.\d+)?)`
+
+	// decimalCommaNumber matches numbers with a decimal comma, which is only
+	// told apart from a thousands separator by having two digits after it.
+	decimalCommaNumber = `\d{1,3}(?:\.\d{3})*,\d{2}\b`
+	decimalComma       = regexp.MustCompile(`^` + decimalCommaNumber + `$`)
+
+	currencyPrefixedPrice = regexp.MustCompile(`(?i)(\$|€|£|usd|cad|eur|gbp)\s*` + priceNumber)
+	currencySuffixedPrice = regexp.MustCompile(`(?i)` + priceNumber + `\s*(usd|cad|eur|gbp|dollars?)\b`)
+	barePrice             = regexp.MustCompile(priceNumber)
+
+	// rentalLength matches a stated rental length such as "8 hours" or
+	// "4-hr".
+	rentalLength = regexp.MustCompile(`(?i)(\d+(?:\.\d+)?)\s*-?\s*(?:hours?|hrs?)\b`)
+
+	// rangeEnd matches the upper end of a range written without a second
+	// currency symbol, as in "$150 - 250" or "$150 to 250".
+	rangeEnd = regexp.MustCompile(`^\s*(?:-|–|—|to)\s*` + priceNumber)
+
+	// phoneNumber matches "555-1234", "512-555-0100" and "(512) 555-0100",
+	// which would otherwise read as bare price ranges.
+	phoneNumber = regexp.MustCompile(`(?:\(\d{3}\)\s*|\b\d{3}[-.\s])?\b\d{3}[-.]\d{4}\b`)
+)
+
+var currencyCodes = map[string]string{
+	"$":       "USD",
+	"usd":     "USD",
+	"dollar":  "USD",
+	"dollars": "USD",
+	"cad":     "CAD",
+	"€":       "EUR",
+	"eur":     "EUR",
+	"£":       "GBP",
+	"gbp":     "GBP",
+}
+
+// perUnitPrice matches explicit rate units such as "/hr", "per day" or
+// "an hour". A number in front of the unit ("for 8 hours") describes the
+// rental length rather than the rate, so it isn't matched here.
+var perUnitPrice = regexp.MustCompile(`(?i)(?:/|\bper\b|\ban?\b|\beach\b)\s*(hours?|hrs?|days?|weekends?|events?|party|parties)\b`)
+
+// priceUnitWords maps words that imply a unit on their own. Words are matched
+// whole, so "Saturday" doesn't count as "day".
+var priceUnitWords = map[string]PriceUnit{
+	"hourly":   PriceUnitHour,
+	"daily":    PriceUnitDay,
+	"weekend":  PriceUnitWeekend,
+	"weekends": PriceUnitWeekend,
+}
+
+// ParsePrice normalizes a price string such as "$189.99", "From $150",
+// "$150-$250" or "$45/hr". Numbers attached to a currency are preferred over
+// bare numbers, so "$45/hr (2 hr minimum)" yields 45 rather than a 2-45
+// range.
+func ParsePrice(s string) (Price, error) {
+	price := Price{Raw: strings.TrimSpace(s)}
+
+	var amounts []float64
+	for _, m := range currencyPrefixedPrice.FindAllStringSubmatchIndex(s, -1) {
+		price.Currency = currencyCodes[strings.ToLower(s[m[2]:m[3]])]
+		amounts = append(amounts, parsePriceNumber(s[m[4]:m[5]]))
+		if end := rangeEnd.FindStringSubmatch(s[m[1]:]); end != nil {
+			amounts = append(amounts, parsePriceNumber(end[1]))
+		}
+	}
+	if len(amounts) == 0 {
+		for _, m := range currencySuffixedPrice.FindAllStringSubmatch(s, -1) {
+			price.Currency = currencyCodes[strings.ToLower(m[2])]
+			amounts = append(amounts, parsePriceNumber(m[1]))
+		}
+	}
+	if len(amounts) == 0 {
+		amounts = parseBarePrice(s)
+	}
+	if len(amounts) == 0 {
+		return Price{}, fmt.Errorf("no price found in %q", s)
+	}
+
+	price.Min, price.Max = amounts[0], amounts[0]
+	for _, amount := range amounts[1:] {
+		if amount < price.Min {
+			price.Min = amount
+		}
+		if amount > price.Max {
+			price.Max = amount
+		}
+	}
+
+	price.Unit = parsePriceUnit(s)
+	return price, nil
+}
+
+// parseBarePrice reads a price without a currency: the first number that
+// isn't a rental length or part of a phone number, and the upper end of an
+// explicit "a - b" or "a to b" range following it. Other numbers are
+// ignored, so "199 for 8 hours" is 199 rather than an 8-199 range.
+func parseBarePrice(s string) []float64 {
+	blank := func(m string) string { return strings.Repeat(" ", len(m)) }
+	s = rentalLength.ReplaceAllStringFunc(s, blank)
+	s = phoneNumber.ReplaceAllStringFunc(s, blank)
+
+	m := barePrice.FindStringSubmatchIndex(s)
+	if m == nil {
+		return nil
+	}
+	amounts := []float64{parsePriceNumber(s[m[2]:m[3]])}
+	if end := rangeEnd.FindStringSubmatch(s[m[1]:]); end != nil {
+		amounts = append(amounts, parsePriceNumber(end[1]))
+	}
+	return amounts
+}
+
+// IsZero reports whether no price has been set.
+func (p Price) IsZero() bool {
+	return p.Min == 0 && p.Max == 0
+}
+
+// UnmarshalJSON accepts the structured form as well as the bare number that
+// older snapshots stored and a raw price string.
+func (p *Price) UnmarshalJSON(data []byte) error {
+	var amount float64
+	if err := json.Unmarshal(data, &amount); err == nil {
+		*p = Price{Min: amount, Max: amount}
+		return nil
+	}
+
+	var raw string
+	if err := json.Unmarshal(data, &raw); err == nil {
+		parsed, err := ParsePrice(raw)
+		if err != nil {
+			return err
+		}
+		*p = parsed
+		return nil
+	}
+
+	type price Price // avoid recursing into this method
+	var structured price
+	if err := json.Unmarshal(data, &structured); err != nil {
+		return err
+	}
+	*p = Price(structured)
+	return nil
+}
+
+func parsePriceNumber(s string) float64 {
+	if decimalComma.MatchString(s) {
+		s = strings.ReplaceAll(s, ".", "")
+		s = strings.ReplaceAll(s, ",", ".")
+	}
+	// The regexp only matches digits, commas and a decimal point, so this
+	// can't fail.
+	amount, _ := strconv.ParseFloat(strings.ReplaceAll(s, ",", ""), 64)
+	return amount
+}
+
+func parsePriceUnit(s string) PriceUnit {
+	if m := perUnitPrice.FindStringSubmatch(s); m != nil {
+		switch unit := strings.ToLower(m[1]); {
+		case strings.HasPrefix(unit, "h"):
+			return PriceUnitHour
+		case strings.HasPrefix(unit, "d"):
+			return PriceUnitDay
+		case strings.HasPrefix(unit, "w"):
+			return PriceUnitWeekend
+		default:
+			return PriceUnitEvent
+		}
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return r < 'a' || r > 'z'
+	})
+	for _, word := range words {
+		if unit, ok := priceUnitWords[word]; ok {
+			return unit
+		}
+	}
+	return PriceUnitUnknown
+}
+
+// Assumptions used to compare prices quoted for different rental periods.
+const (
+	// standardRentalDayHours is the length of a typical party rental.
+	standardRentalDayHours = 8
+	// weekendRentalDays is how many rental days a weekend rate covers.
+	weekendRentalDays = 2
+)
+
+// DailyRate normalizes the product's base rate to a standard rental day so
+// hourly, daily and weekend prices can be averaged together. Delivery fees
+// and deposits are not included.
+func (p Product) DailyRate() float64 {
+	base := p.Price.Min
+
+	switch p.Price.Unit {
+	case PriceUnitHour:
+		return base * standardRentalDayHours
+	case PriceUnitWeekend:
+		return base / weekendRentalDays
+	}
+
+	// Day, event and unknown rates cover one rental; adjust them when the
+	// site says that rental is shorter or longer than a standard day.
+	if p.RentalHours <= 0 || p.RentalHours == standardRentalDayHours {
+		return base
+	}
+	if p.RentalHours < standardRentalDayHours && p.ExtraHourRate > 0 {
+		return base + (standardRentalDayHours-p.RentalHours)*p.ExtraHourRate
+	}
+	return base * standardRentalDayHours / p.RentalHours
+}
+
+// parseRentalHours returns the rental length stated in s, or zero when none
+// is given.
+func parseRentalHours(s string) float64 {
+	m := rentalLength.FindStringSubmatch(s)
+	if m == nil {
+		return 0
+	}
+	hours, _ := strconv.ParseFloat(m[1], 64)
+	return hours
+}
+
+// parseOptionalAmount returns the lowest amount in s, or zero when s holds no
+// price.
+func parseOptionalAmount(s string) float64 {
+	price, err := ParsePrice(s)
+	if err != nil {
+		return 0
+	}
+	return price.Min
+}
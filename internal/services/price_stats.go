@@ -0,0 +1,127 @@
+package services
+
+import (
+	"math"
+	"sort"
+)
+
+// PriceStats summarizes a set of per-day prices.
+type PriceStats struct {
+	Count  int     `json:"count"`
+	Mean   float64 `json:"mean"`
+	Median float64 `json:"median"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	StdDev float64 `json:"stdDev"`
+	P10    float64 `json:"p10"`
+	P25    float64 `json:"p25"`
+	P75    float64 `json:"p75"`
+	P90    float64 `json:"p90"`
+	// TrimmedMean is the mean after dropping values outside 1.5 IQR of the
+	// quartiles, so a single mis-scraped listing doesn't skew it.
+	TrimmedMean     float64 `json:"trimmedMean"`
+	OutliersRemoved int     `json:"outliersRemoved"`
+}
+
+// CompetitorPriceStats are the price statistics of one competitor.
+type CompetitorPriceStats struct {
+	Name           string   `json:"name"`
+	Website        string   `json:"website"`
+	DistanceMeters *float64 `json:"distanceMeters,omitempty"`
+	PriceStats
+}
+
+// PriceDistribution is the market price distribution for a category in a
+// location, overall and per competitor.
+type PriceDistribution struct {
+	Location    string                 `json:"location"`
+	Category    string                 `json:"category"`
+	Overall     PriceStats             `json:"overall"`
+	Competitors []CompetitorPriceStats `json:"competitors"`
+}
+
+// computePriceStats summarizes values, which must be sorted ascending.
+func computePriceStats(sorted []float64) PriceStats {
+	stats := PriceStats{Count: len(sorted)}
+	if len(sorted) == 0 {
+		return stats
+	}
+
+	stats.Min = sorted[0]
+	stats.Max = sorted[len(sorted)-1]
+	stats.Mean = mean(sorted)
+	stats.Median = percentile(sorted, 50)
+	stats.P10 = percentile(sorted, 10)
+	stats.P25 = percentile(sorted, 25)
+	stats.P75 = percentile(sorted, 75)
+	stats.P90 = percentile(sorted, 90)
+
+	var sumSquares float64
+	for _, v := range sorted {
+		sumSquares += (v - stats.Mean) * (v - stats.Mean)
+	}
+	stats.StdDev = math.Sqrt(sumSquares / float64(len(sorted)))
+
+	iqr := stats.P75 - stats.P25
+	low, high := stats.P25-1.5*iqr, stats.P75+1.5*iqr
+	var kept []float64
+	for _, v := range sorted {
+		if v >= low && v <= high {
+			kept = append(kept, v)
+		}
+	}
+	stats.TrimmedMean = mean(kept)
+	stats.OutliersRemoved = len(sorted) - len(kept)
+
+	return stats
+}
+
+// buildPriceDistribution computes the distribution of category prices among
+// the competitors of a location. It returns nil if no product matches.
+func buildPriceDistribution(locationName string, competitors []Competitor, category string) *PriceDistribution {
+	distribution := &PriceDistribution{
+		Location: locationName,
+		Category: category,
+	}
+
+	var all []float64
+	for _, competitor := range competitors {
+		var rates []float64
+		for _, product := range competitor.Products {
+			if productCategory(product) == category {
+				rates = append(rates, product.DailyRate())
+			}
+		}
+		if len(rates) == 0 {
+			continue
+		}
+
+		sort.Float64s(rates)
+		all = append(all, rates...)
+		distribution.Competitors = append(distribution.Competitors, CompetitorPriceStats{
+			Name:           competitor.Name,
+			Website:        competitor.Website,
+			DistanceMeters: competitor.DistanceMeters,
+			PriceStats:     computePriceStats(rates),
+		})
+	}
+
+	if len(all) == 0 {
+		return nil
+	}
+
+	sort.Float64s(all)
+	distribution.Overall = computePriceStats(all)
+	return distribution
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var total float64
+	for _, v := range values {
+		total += v
+	}
+	return total / float64(len(values))
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"math"
+	"testing"
+)
+
+func TestComputePriceStats(t *testing.T) {
+	stats := computePriceStats([]float64{150, 175, 200, 225, 250, 4000})
+
+	if stats.Count != 6 || stats.Min != 150 || stats.Max != 4000 {
+		t.Errorf("count/min/max = %d/%v/%v", stats.Count, stats.Min, stats.Max)
+	}
+	if stats.Median != 212.5 {
+		t.Errorf("Median = %v, want 212.5", stats.Median)
+	}
+	if stats.OutliersRemoved != 1 {
+		t.Errorf("OutliersRemoved = %d, want 1", stats.OutliersRemoved)
+	}
+	if stats.TrimmedMean != 200 {
+		t.Errorf("TrimmedMean = %v, want 200", stats.TrimmedMean)
+	}
+	if stats.Mean <= stats.TrimmedMean {
+		t.Errorf("Mean = %v, want it skewed above the trimmed mean", stats.Mean)
+	}
+}
+
+func TestComputePriceStatsSingleValue(t *testing.T) {
+	stats := computePriceStats([]float64{180})
+	if stats.Mean != 180 || stats.Median != 180 || stats.P90 != 180 || stats.StdDev != 0 || stats.TrimmedMean != 180 {
+		t.Errorf("stats = %+v, want every figure at 180", stats)
+	}
+}
+
+func TestBuildPriceDistribution(t *testing.T) {
+	location := &Location{
+		Name: "Austin",
+		Competitors: []Competitor{
+			{Name: "A", Products: []Product{
+				{Name: "Castle", Category: CategoryBounceHouse, Price: Price{Min: 150}},
+				{Name: "Tent", Category: CategoryTent, Price: Price{Min: 300}},
+			}},
+			{Name: "B", Products: []Product{
+				{Name: "Jumper", Category: CategoryBounceHouse, Price: Price{Min: 25, Unit: PriceUnitHour}},
+			}},
+			{Name: "C", Products: []Product{
+				{Name: "Slide", Category: CategoryDrySlide, Price: Price{Min: 250}},
+			}},
+		},
+	}
+
+	distribution := buildPriceDistribution(location.Name, location.Competitors, CategoryBounceHouse)
+	if distribution == nil {
+		t.Fatal("buildPriceDistribution returned nil")
+	}
+	if distribution.Overall.Count != 2 || math.Abs(distribution.Overall.Mean-175) > 1e-9 {
+		t.Errorf("Overall = %+v, want 2 products averaging 175", distribution.Overall)
+	}
+	if len(distribution.Competitors) != 2 {
+		t.Errorf("got %d competitors, want 2", len(distribution.Competitors))
+	}
+
+	if buildPriceDistribution(location.Name, location.Competitors, CategoryConcession) != nil {
+		t.Error("buildPriceDistribution returned data for a category with no products")
+	}
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParsePrice(t *testing.T) {
+	tests := []struct {
+		in       string
+		min, max float64
+		currency string
+		unit     PriceUnit
+	}{
+		{"150", 150, 150, "", PriceUnitUnknown},
+		{"$189.99", 189.99, 189.99, "USD", PriceUnitUnknown},
+		{"From $150", 150, 150, "USD", PriceUnitUnknown},
+		{"Starting at $99", 99, 99, "USD", PriceUnitUnknown},
+		{"$150-$250", 150, 250, "USD", PriceUnitUnknown},
+		{"$150 - 250", 150, 250, "USD", PriceUnitUnknown},
+		{"$150 to $250 per day", 150, 250, "USD", PriceUnitDay},
+		{"$250 – $150", 150, 250, "USD", PriceUnitUnknown},
+		{"$1,299.00", 1299, 1299, "USD", PriceUnitUnknown},
+		{"$45/hr", 45, 45, "USD", PriceUnitHour},
+		{"$45 / hour (2 hr minimum)", 45, 45, "USD", PriceUnitHour},
+		{"$60 per hour", 60, 60, "USD", PriceUnitHour},
+		{"Hourly rate: $35", 35, 35, "USD", PriceUnitHour},
+		{"$199 for 8 hours", 199, 199, "USD", PriceUnitUnknown},
+		{"$175/day", 175, 175, "USD", PriceUnitDay},
+		{"$175 a day", 175, 175, "USD", PriceUnitDay},
+		{"$300 Weekend Special", 300, 300, "USD", PriceUnitWeekend},
+		{"$325 per weekend", 325, 325, "USD", PriceUnitWeekend},
+		{"$225 per event", 225, 225, "USD", PriceUnitEvent},
+		{"Saturday only $200", 200, 200, "USD", PriceUnitUnknown},
+		{"250 USD", 250, 250, "USD", PriceUnitUnknown},
+		{"CAD 180", 180, 180, "CAD", PriceUnitUnknown},
+		{"£95", 95, 95, "GBP", PriceUnitUnknown},
+		{"€120,50", 120.5, 120.5, "EUR", PriceUnitUnknown},
+		{"1.299,00 EUR", 1299, 1299, "EUR", PriceUnitUnknown},
+		{"$1,299", 1299, 1299, "USD", PriceUnitUnknown},
+		{"  $ 95.00  ", 95, 95, "USD", PriceUnitUnknown},
+		{"199 for 8 hours", 199, 199, "", PriceUnitUnknown},
+		{"150.00 - 6 hr rental", 150, 150, "", PriceUnitUnknown},
+		{"4-hr rental 175", 175, 175, "", PriceUnitUnknown},
+		{"150 - 250", 150, 250, "", PriceUnitUnknown},
+		{"150 to 250 per day", 150, 250, "", PriceUnitDay},
+		{"150, call 512-555-0100 to book", 150, 150, "", PriceUnitUnknown},
+	}
+
+	for _, tt := range tests {
+		got, err := ParsePrice(tt.in)
+		if err != nil {
+			t.Errorf("ParsePrice(%q) error: %v", tt.in, err)
+			continue
+		}
+		if got.Min != tt.min || got.Max != tt.max || got.Currency != tt.currency || got.Unit != tt.unit {
+			t.Errorf("ParsePrice(%q) = %+v, want min=%v max=%v currency=%q unit=%q", tt.in, got, tt.min, tt.max, tt.currency, tt.unit)
+		}
+	}
+}
+
+func TestParsePriceRejectsMissingPrice(t *testing.T) {
+	for _, in := range []string{
+		"", "Call for pricing", "Contact us",
+		"Call 555-1234", "(512) 555-0100", "6 hour rental",
+	} {
+		if got, err := ParsePrice(in); err == nil {
+			t.Errorf("ParsePrice(%q) = %+v, want error", in, got)
+		}
+	}
+}
+
+func TestPriceUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in       string
+		min, max float64
+	}{
+		{`150`, 150, 150},
+		{`"$150-$250"`, 150, 250},
+		{`{"min":100,"max":120,"unit":"day"}`, 100, 120},
+	}
+
+	for _, tt := range tests {
+		var got Price
+		if err := json.Unmarshal([]byte(tt.in), &got); err != nil {
+			t.Errorf("Unmarshal(%s) error: %v", tt.in, err)
+			continue
+		}
+		if got.Min != tt.min || got.Max != tt.max {
+			t.Errorf("Unmarshal(%s) = %+v, want min=%v max=%v", tt.in, got, tt.min, tt.max)
+		}
+	}
+}
+
+func TestProductDailyRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		product Product
+		want    float64
+	}{
+		{"day rate", Product{Price: Price{Min: 200, Unit: PriceUnitDay}}, 200},
+		{"unknown unit", Product{Price: Price{Min: 175}}, 175},
+		{"hourly", Product{Price: Price{Min: 40, Unit: PriceUnitHour}}, 320},
+		{"weekend", Product{Price: Price{Min: 300, Unit: PriceUnitWeekend}}, 150},
+		{"standard day stated", Product{Price: Price{Min: 180}, RentalHours: 8}, 180},
+		{"short rental with extra hours", Product{Price: Price{Min: 150}, RentalHours: 4, ExtraHourRate: 20}, 230},
+		{"short rental without extra hours", Product{Price: Price{Min: 100}, RentalHours: 4}, 200},
+		{"24 hour rental", Product{Price: Price{Min: 300}, RentalHours: 24}, 100},
+	}
+
+	for _, tt := range tests {
+		if got := tt.product.DailyRate(); got != tt.want {
+			t.Errorf("%s: DailyRate() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseRentalHours(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+	}{
+		{"$199 for 8 hours", 8},
+		{"4-hr rental", 4},
+		{"6.5 hrs", 6.5},
+		{"$45/hr", 0},
+		{"per day", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseRentalHours(tt.in); got != tt.want {
+			t.Errorf("parseRentalHours(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Defaults applied to PurchaseCosts fields left at zero.
+const (
+	defaultBookingsPerMonth   = 4
+	defaultDepreciationMonths = 36
+	// maxDepreciationMonths bounds the projection, which holds a row per
+	// month.
+	maxDepreciationMonths = 120
+)
+
+// sensitivityPercentiles are the points of the local price distribution the
+// sensitivity table is computed at.
+var sensitivityPercentiles = []float64{10, 25, 50, 75, 90}
+
+// PurchaseCosts are the optional operating assumptions for owning a rental
+// unit. Per-rental costs are paid every booking, monthly costs every month.
+type PurchaseCosts struct {
+	CleaningPerRental float64 `json:"cleaningPerRental"`
+	LaborPerRental    float64 `json:"laborPerRental"`
+	DeliveryPerRental float64 `json:"deliveryPerRental"`
+	InsuranceMonthly  float64 `json:"insuranceMonthly"`
+	StorageMonthly    float64 `json:"storageMonthly"`
+	BookingsPerMonth  float64 `json:"bookingsPerMonth"`
+	// Seasonality holds 12 multipliers applied to BookingsPerMonth, January
+	// first. Leave empty for a flat year.
+	Seasonality []float64 `json:"seasonality"`
+	// DepreciationMonths is the useful life of the unit.
+	DepreciationMonths int `json:"depreciationMonths"`
+	// StartMonth is the calendar month (1-12) the projection starts in;
+	// zero means the current month.
+	StartMonth int `json:"startMonth"`
+}
+
+// Validate reports the first invalid assumption.
+func (c PurchaseCosts) Validate() error {
+	// In field order, so the same request always reports the same problem
+	amounts := []struct {
+		name   string
+		amount float64
+	}{
+		{"cleaningPerRental", c.CleaningPerRental},
+		{"laborPerRental", c.LaborPerRental},
+		{"deliveryPerRental", c.DeliveryPerRental},
+		{"insuranceMonthly", c.InsuranceMonthly},
+		{"storageMonthly", c.StorageMonthly},
+		{"bookingsPerMonth", c.BookingsPerMonth},
+	}
+	for _, a := range amounts {
+		if a.amount < 0 {
+			return fmt.Errorf("%s cannot be negative", a.name)
+		}
+	}
+	if c.DepreciationMonths < 0 || c.DepreciationMonths > maxDepreciationMonths {
+		return fmt.Errorf("depreciationMonths must be between 0 and %d", maxDepreciationMonths)
+	}
+	if c.StartMonth < 0 || c.StartMonth > 12 {
+		return fmt.Errorf("startMonth must be between 1 and 12")
+	}
+	if len(c.Seasonality) != 0 && len(c.Seasonality) != 12 {
+		return fmt.Errorf("seasonality must have 12 monthly values")
+	}
+	for _, multiplier := range c.Seasonality {
+		if multiplier < 0 {
+			return fmt.Errorf("seasonality values cannot be negative")
+		}
+	}
+	return nil
+}
+
+func (c PurchaseCosts) withDefaults() PurchaseCosts {
+	if c.BookingsPerMonth == 0 {
+		c.BookingsPerMonth = defaultBookingsPerMonth
+	}
+	if c.DepreciationMonths == 0 {
+		c.DepreciationMonths = defaultDepreciationMonths
+	}
+	if c.StartMonth == 0 {
+		c.StartMonth = int(time.Now().Month())
+	}
+	if len(c.Seasonality) == 0 {
+		c.Seasonality = make([]float64, 12)
+		for i := range c.Seasonality {
+			c.Seasonality[i] = 1
+		}
+	}
+	return c
+}
+
+func (c PurchaseCosts) perRental() float64 {
+	return c.CleaningPerRental + c.LaborPerRental + c.DeliveryPerRental
+}
+
+func (c PurchaseCosts) monthly() float64 {
+	return c.InsuranceMonthly + c.StorageMonthly
+}
+
+// MonthlyCashFlow is one month of the projection.
+type MonthlyCashFlow struct {
+	Month      int     `json:"month"`
+	Bookings   float64 `json:"bookings"`
+	Revenue    float64 `json:"revenue"`
+	Costs      float64 `json:"costs"`
+	Net        float64 `json:"net"`
+	Cumulative float64 `json:"cumulative"`
+}
+
+// PurchaseProjection is the outcome of renting at one price. Break-even
+// fields are nil when the unit never pays for itself.
+type PurchaseProjection struct {
+	PricePerRental    float64 `json:"pricePerRental"`
+	NetPerRental      float64 `json:"netPerRental"`
+	BreakEvenRentals  *int    `json:"breakEvenRentals"`
+	MonthsToBreakEven *int    `json:"monthsToBreakEven"`
+	LifetimeProfit    float64 `json:"lifetimeProfit"`
+	// ROI is the lifetime profit as a fraction of the purchase price.
+	ROI float64 `json:"roi"`
+}
+
+// SensitivityRow is the projection at one percentile of local prices.
+type SensitivityRow struct {
+	Percentile float64 `json:"percentile"`
+	PurchaseProjection
+}
+
+// PurchaseAnalysis is the result of AnalyzePurchase, based on the average
+// local price.
+type PurchaseAnalysis struct {
+	PurchasePrice float64 `json:"purchasePrice"`
+	AveragePrice  float64 `json:"averagePrice"`
+	PurchaseProjection
+	Assumptions PurchaseCosts     `json:"assumptions"`
+	CashFlow    []MonthlyCashFlow `json:"cashFlow"`
+	Sensitivity []SensitivityRow  `json:"sensitivity"`
+}
+
+// analyzePurchase runs the projection at the mean of rates and at each
+// sensitivity percentile. rates must be sorted and costs must have defaults
+// applied.
+func analyzePurchase(purchasePrice float64, rates []float64, costs PurchaseCosts) *PurchaseAnalysis {
+	average := mean(rates)
+
+	projection, cashFlow := projectPurchase(purchasePrice, average, costs)
+	analysis := &PurchaseAnalysis{
+		PurchasePrice:      purchasePrice,
+		AveragePrice:       average,
+		PurchaseProjection: projection,
+		Assumptions:        costs,
+		CashFlow:           cashFlow,
+	}
+
+	for _, p := range sensitivityPercentiles {
+		row, _ := projectPurchase(purchasePrice, percentile(rates, p), costs)
+		analysis.Sensitivity = append(analysis.Sensitivity, SensitivityRow{
+			Percentile:         p,
+			PurchaseProjection: row,
+		})
+	}
+
+	return analysis
+}
+
+// projectPurchase computes month-by-month cash flow over the unit's
+// depreciation life when renting at price.
+func projectPurchase(purchasePrice, price float64, costs PurchaseCosts) (PurchaseProjection, []MonthlyCashFlow) {
+	projection := PurchaseProjection{
+		PricePerRental: price,
+		NetPerRental:   price - costs.perRental(),
+	}
+	if projection.NetPerRental > 0 {
+		rentals := int(math.Ceil(purchasePrice / projection.NetPerRental))
+		projection.BreakEvenRentals = &rentals
+	}
+
+	cashFlow := make([]MonthlyCashFlow, 0, costs.DepreciationMonths)
+	cumulative := -purchasePrice
+	for month := 1; month <= costs.DepreciationMonths; month++ {
+		calendarMonth := (costs.StartMonth - 1 + month - 1) % 12
+		bookings := costs.BookingsPerMonth * costs.Seasonality[calendarMonth]
+		revenue := bookings * price
+		spend := bookings*costs.perRental() + costs.monthly()
+		net := revenue - spend
+		cumulative += net
+
+		cashFlow = append(cashFlow, MonthlyCashFlow{
+			Month:      month,
+			Bookings:   bookings,
+			Revenue:    revenue,
+			Costs:      spend,
+			Net:        net,
+			Cumulative: cumulative,
+		})
+		if projection.MonthsToBreakEven == nil && cumulative >= 0 {
+			m := month
+			projection.MonthsToBreakEven = &m
+		}
+	}
+
+	projection.LifetimeProfit = cumulative
+	if purchasePrice > 0 {
+		projection.ROI = cumulative / purchasePrice
+	}
+	return projection, cashFlow
+}
+
+// percentile returns the p-th percentile (0-100) of sorted values using
+// linear interpolation between closest ranks.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
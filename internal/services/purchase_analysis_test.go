@@ -0,0 +1,105 @@
+package services
+
+import (
+	"math"
+	"testing"
+)
+
+func TestProjectPurchase(t *testing.T) {
+	costs := PurchaseCosts{
+		CleaningPerRental:  20,
+		LaborPerRental:     30,
+		InsuranceMonthly:   25,
+		StorageMonthly:     25,
+		BookingsPerMonth:   4,
+		DepreciationMonths: 12,
+	}.withDefaults()
+
+	projection, cashFlow := projectPurchase(1000, 150, costs)
+
+	if projection.NetPerRental != 100 {
+		t.Errorf("NetPerRental = %v, want 100", projection.NetPerRental)
+	}
+	if projection.BreakEvenRentals == nil || *projection.BreakEvenRentals != 10 {
+		t.Errorf("BreakEvenRentals = %v, want 10", projection.BreakEvenRentals)
+	}
+	// Each month nets 4*100 - 50 = 350, so month 3 is the first >= 1000.
+	if projection.MonthsToBreakEven == nil || *projection.MonthsToBreakEven != 3 {
+		t.Errorf("MonthsToBreakEven = %v, want 3", projection.MonthsToBreakEven)
+	}
+	if len(cashFlow) != 12 {
+		t.Fatalf("got %d months of cash flow, want 12", len(cashFlow))
+	}
+	if want := 12*350.0 - 1000; projection.LifetimeProfit != want {
+		t.Errorf("LifetimeProfit = %v, want %v", projection.LifetimeProfit, want)
+	}
+	if want := (12*350.0 - 1000) / 1000; math.Abs(projection.ROI-want) > 1e-9 {
+		t.Errorf("ROI = %v, want %v", projection.ROI, want)
+	}
+}
+
+func TestProjectPurchaseRoundsBreakEvenUp(t *testing.T) {
+	projection, _ := projectPurchase(1000, 300, PurchaseCosts{}.withDefaults())
+	if projection.BreakEvenRentals == nil || *projection.BreakEvenRentals != 4 {
+		t.Errorf("BreakEvenRentals = %v, want 4", projection.BreakEvenRentals)
+	}
+}
+
+func TestProjectPurchaseNeverBreaksEven(t *testing.T) {
+	costs := PurchaseCosts{CleaningPerRental: 200}.withDefaults()
+	projection, _ := projectPurchase(1000, 150, costs)
+	if projection.BreakEvenRentals != nil || projection.MonthsToBreakEven != nil {
+		t.Errorf("projection = %+v, want no break-even", projection)
+	}
+}
+
+func TestProjectPurchaseSeasonality(t *testing.T) {
+	costs := PurchaseCosts{
+		BookingsPerMonth:   10,
+		DepreciationMonths: 2,
+		StartMonth:         12,
+		Seasonality:        []float64{0.5, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 2},
+	}.withDefaults()
+
+	_, cashFlow := projectPurchase(0, 100, costs)
+	if cashFlow[0].Bookings != 20 || cashFlow[1].Bookings != 5 {
+		t.Errorf("bookings = %v, %v, want December 20 then January 5", cashFlow[0].Bookings, cashFlow[1].Bookings)
+	}
+}
+
+func TestPurchaseCostsValidate(t *testing.T) {
+	invalid := []PurchaseCosts{
+		{CleaningPerRental: -1},
+		{Seasonality: []float64{1, 1}},
+		{StartMonth: 13},
+		{DepreciationMonths: -3},
+		{DepreciationMonths: 121},
+		{DepreciationMonths: 2000000000},
+	}
+	for _, costs := range invalid {
+		if err := costs.Validate(); err == nil {
+			t.Errorf("Validate(%+v) = nil, want error", costs)
+		}
+	}
+	if err := (PurchaseCosts{}).Validate(); err != nil {
+		t.Errorf("Validate(zero) = %v, want nil", err)
+	}
+
+	// The first invalid field is reported every time
+	costs := PurchaseCosts{LaborPerRental: -1, StorageMonthly: -1, BookingsPerMonth: -1}
+	for i := 0; i < 10; i++ {
+		if err := costs.Validate(); err == nil || err.Error() != "laborPerRental cannot be negative" {
+			t.Fatalf("Validate(%+v) = %v, want laborPerRental reported", costs, err)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	values := []float64{100, 200, 300, 400, 500}
+	tests := map[float64]float64{0: 100, 25: 200, 50: 300, 90: 460, 100: 500}
+	for p, want := range tests {
+		if got := percentile(values, p); math.Abs(got-want) > 1e-9 {
+			t.Errorf("percentile(%v) = %v, want %v", p, got, want)
+		}
+	}
+}
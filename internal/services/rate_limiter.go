@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ErrRateLimited is returned when a request gives up waiting for a rate limit
+// token, either through Allow or because the context of Wait ended.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// RateLimiter is a token bucket holding up to maxTokens tokens, refilled one
+// every tokenInterval. Tokens are refilled lazily when requested, so an idle
+// limiter costs nothing and needs no stopping.
+type RateLimiter struct {
+	limiter *rate.Limiter
+
+	mu    sync.Mutex
+	stats RateLimiterStats
+}
+
+// RateLimiterStats counts how a limiter has been used.
+type RateLimiterStats struct {
+	// Acquired is the number of tokens handed out.
+	Acquired int64 `json:"acquired"`
+	// Rejected is the number of requests that gave up without a token.
+	Rejected int64 `json:"rejected"`
+	// Waited is the number of acquisitions that had to block.
+	Waited int64 `json:"waited"`
+	// TotalWait and MaxWait cover the time spent blocked in Wait.
+	TotalWait time.Duration `json:"totalWait"`
+	MaxWait   time.Duration `json:"maxWait"`
+}
+
+func NewRateLimiter(maxTokens int, tokenInterval time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limiter: rate.NewLimiter(rate.Every(tokenInterval), maxTokens),
+	}
+}
+
+// Allow takes a token if one is available without blocking.
+func (rl *RateLimiter) Allow() bool {
+	allowed := rl.limiter.Allow()
+	rl.record(0, allowed)
+	return allowed
+}
+
+// Wait blocks until a token is available or ctx ends. The returned error
+// wraps both ErrRateLimited and the context error.
+func (rl *RateLimiter) Wait(ctx context.Context) error {
+	reservation := rl.limiter.Reserve()
+	delay := reservation.Delay()
+	if delay == 0 {
+		rl.record(0, true)
+		return nil
+	}
+
+	start := time.Now()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		rl.record(time.Since(start), true)
+		return nil
+	case <-ctx.Done():
+		// Hand the token back to the requests still waiting
+		reservation.Cancel()
+		rl.record(time.Since(start), false)
+		return fmt.Errorf("%w: %w", ErrRateLimited, ctx.Err())
+	}
+}
+
+// idle reports whether the bucket has refilled completely, so replacing the
+// limiter with a new one wouldn't change what it allows.
+func (rl *RateLimiter) idle(now time.Time) bool {
+	return rl.limiter.TokensAt(now) >= float64(rl.limiter.Burst())
+}
+
+// Stats returns the usage counters of the limiter.
+func (rl *RateLimiter) Stats() RateLimiterStats {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	return rl.stats
+}
+
+func (rl *RateLimiter) record(waited time.Duration, acquired bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if acquired {
+		rl.stats.Acquired++
+	} else {
+		rl.stats.Rejected++
+	}
+	if waited > 0 {
+		rl.stats.Waited++
+		rl.stats.TotalWait += waited
+		if waited > rl.stats.MaxWait {
+			rl.stats.MaxWait = waited
+		}
+	}
+}
+
+// RateBudget allows Requests requests per Per, in bursts of up to Requests.
+type RateBudget struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseRateBudget parses a budget written as "<requests>/<duration>", such as
+// "5/1s" or "100/1m". The duration may omit its count: "5/s".
+func ParseRateBudget(s string) (RateBudget, error) {
+	requests, per, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return RateBudget{}, fmt.Errorf("invalid rate budget %q, want <requests>/<duration>", s)
+	}
+
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 1 {
+		return RateBudget{}, fmt.Errorf("invalid request count in rate budget %q", s)
+	}
+
+	if per != "" && (per[0] < '0' || per[0] > '9') {
+		per = "1" + per
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return RateBudget{}, fmt.Errorf("invalid duration in rate budget %q", s)
+	}
+
+	return RateBudget{Requests: n, Per: d}, nil
+}
+
+func (b RateBudget) newLimiter() *RateLimiter {
+	return NewRateLimiter(b.Requests, b.Per/time.Duration(b.Requests))
+}
+
+// RateLimitConfig holds the budget of each upstream. Zero budgets fall back
+// to DefaultRateLimitConfig.
+type RateLimitConfig struct {
+	Firecrawl RateBudget
+	Places    RateBudget
+	// Domain is applied separately to every competitor domain scraped
+	// through Firecrawl.
+	Domain RateBudget
+}
+
+func DefaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		Firecrawl: RateBudget{Requests: 5, Per: time.Second},
+		Places:    RateBudget{Requests: 10, Per: time.Second},
+		Domain:    RateBudget{Requests: 1, Per: 3 * time.Second},
+	}
+}
+
+// domainIdleTimeout is how long the limiter of a competitor domain is kept
+// after its last use. Searches touch many domains once, so idle ones are
+// dropped rather than kept, and reported, forever.
+const domainIdleTimeout = 10 * time.Minute
+
+// RateLimits holds the limiters for every upstream host.
+type RateLimits struct {
+	Firecrawl *RateLimiter
+	Places    *RateLimiter
+
+	domainBudget RateBudget
+	now          func() time.Time
+	mu           sync.Mutex
+	domains      map[string]*domainLimiter
+	lastEviction time.Time
+}
+
+// domainLimiter is the limiter of one domain and when it was last handed
+// out.
+type domainLimiter struct {
+	*RateLimiter
+	lastUsed time.Time
+}
+
+func NewRateLimits(cfg RateLimitConfig) *RateLimits {
+	defaults := DefaultRateLimitConfig()
+	if cfg.Firecrawl.Requests == 0 {
+		cfg.Firecrawl = defaults.Firecrawl
+	}
+	if cfg.Places.Requests == 0 {
+		cfg.Places = defaults.Places
+	}
+	if cfg.Domain.Requests == 0 {
+		cfg.Domain = defaults.Domain
+	}
+
+	return &RateLimits{
+		Firecrawl:    cfg.Firecrawl.newLimiter(),
+		Places:       cfg.Places.newLimiter(),
+		domainBudget: cfg.Domain,
+		now:          time.Now,
+		domains:      make(map[string]*domainLimiter),
+	}
+}
+
+// Domain returns the limiter for the host of rawURL, creating it on first
+// use. "www." is ignored so both forms of a site share a budget. Limiters
+// unused for domainIdleTimeout are dropped once their bucket is full again.
+func (rl *RateLimits) Domain(rawURL string) *RateLimiter {
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+	host = strings.TrimPrefix(strings.ToLower(host), "www.")
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	rl.evictIdleDomains(now)
+
+	limiter, ok := rl.domains[host]
+	if !ok {
+		limiter = &domainLimiter{RateLimiter: rl.domainBudget.newLimiter()}
+		rl.domains[host] = limiter
+	}
+	limiter.lastUsed = now
+	return limiter.RateLimiter
+}
+
+// evictIdleDomains drops the idle domain limiters, at most once per
+// domainIdleTimeout. rl.mu must be held.
+func (rl *RateLimits) evictIdleDomains(now time.Time) {
+	if now.Sub(rl.lastEviction) < domainIdleTimeout {
+		return
+	}
+	rl.lastEviction = now
+
+	for host, limiter := range rl.domains {
+		if now.Sub(limiter.lastUsed) >= domainIdleTimeout && limiter.idle(now) {
+			delete(rl.domains, host)
+		}
+	}
+}
+
+// Stats returns the counters of every limiter, keyed "firecrawl", "places"
+// and "domain:<host>".
+func (rl *RateLimits) Stats() map[string]RateLimiterStats {
+	stats := map[string]RateLimiterStats{
+		"firecrawl": rl.Firecrawl.Stats(),
+		"places":    rl.Places.Stats(),
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.evictIdleDomains(rl.now())
+	for host, limiter := range rl.domains {
+		stats["domain:"+host] = limiter.Stats()
+	}
+	return stats
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterWait(t *testing.T) {
+	rl := NewRateLimiter(1, 20*time.Millisecond)
+
+	ctx := context.Background()
+	if err := rl.Wait(ctx); err != nil {
+		t.Fatalf("first Wait() = %v", err)
+	}
+
+	start := time.Now()
+	if err := rl.Wait(ctx); err != nil {
+		t.Fatalf("second Wait() = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Errorf("second Wait() returned after %v, want it to block for a refill", elapsed)
+	}
+
+	stats := rl.Stats()
+	if stats.Acquired != 2 || stats.Waited != 1 || stats.TotalWait <= 0 {
+		t.Errorf("Stats() = %+v, want 2 acquired with 1 wait", stats)
+	}
+}
+
+func TestRateLimiterWaitContextDone(t *testing.T) {
+	rl := NewRateLimiter(1, time.Hour)
+	rl.Allow()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := rl.Wait(ctx)
+	if !errors.Is(err, ErrRateLimited) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait() = %v, want ErrRateLimited wrapping the deadline", err)
+	}
+	if stats := rl.Stats(); stats.Rejected != 1 {
+		t.Errorf("Rejected = %d, want 1", stats.Rejected)
+	}
+}
+
+func TestParseRateBudget(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    RateBudget
+		wantErr bool
+	}{
+		{"5/1s", RateBudget{Requests: 5, Per: time.Second}, false},
+		{"100/1m", RateBudget{Requests: 100, Per: time.Minute}, false},
+		{"2/s", RateBudget{Requests: 2, Per: time.Second}, false},
+		{" 1/3s ", RateBudget{Requests: 1, Per: 3 * time.Second}, false},
+		{"5", RateBudget{}, true},
+		{"0/1s", RateBudget{}, true},
+		{"5/soon", RateBudget{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRateBudget(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRateBudget(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRateBudget(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimitsDomain(t *testing.T) {
+	limits := NewRateLimits(RateLimitConfig{})
+
+	a := limits.Domain("https://www.JumpinJacks.example/rentals")
+	b := limits.Domain("https://jumpinjacks.example/")
+	c := limits.Domain("https://bouncebros.example/")
+	if a != b {
+		t.Error("www and bare host got different limiters")
+	}
+	if a == c {
+		t.Error("different domains share a limiter")
+	}
+
+	a.Allow()
+	stats := limits.Stats()
+	if stats["domain:jumpinjacks.example"].Acquired != 1 {
+		t.Errorf("Stats() = %+v, want one acquisition for jumpinjacks.example", stats)
+	}
+}
+
+func TestRateLimitsEvictsIdleDomains(t *testing.T) {
+	limits := NewRateLimits(RateLimitConfig{Domain: RateBudget{Requests: 1, Per: time.Hour}})
+	now := time.Now()
+	limits.now = func() time.Time { return now }
+
+	busy := limits.Domain("https://jumpinjacks.example/")
+	busy.Allow()
+	limits.Domain("https://bouncebros.example/")
+
+	// Only bouncebros.example is idle with a full bucket
+	now = now.Add(domainIdleTimeout)
+	stats := limits.Stats()
+	if _, ok := stats["domain:bouncebros.example"]; ok {
+		t.Errorf("Stats() = %+v, want the idle domain evicted", stats)
+	}
+	if limits.Domain("https://jumpinjacks.example/") != busy {
+		t.Error("domain with an empty bucket was evicted")
+	}
+
+	now = now.Add(time.Hour + domainIdleTimeout)
+	if limits.Domain("https://jumpinjacks.example/") == busy {
+		t.Error("idle domain kept its limiter")
+	}
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// RetryPolicy retries transient failures of external calls with exponential
+// backoff and jitter. Zero fields take the values of DefaultRetryPolicy.
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+	}
+}
+
+// noRetry makes a single attempt.
+var noRetry = RetryPolicy{MaxAttempts: 1}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	defaults := DefaultRetryPolicy()
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = defaults.MaxAttempts
+	}
+	if p.InitialBackoff == 0 {
+		p.InitialBackoff = defaults.InitialBackoff
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = defaults.MaxBackoff
+	}
+	return p
+}
+
+// Do calls operation until it succeeds, fails with an error IsRetryable
+// rejects, runs out of attempts or ctx ends. A Retry-After given by the
+// server replaces the computed backoff; one longer than MaxBackoff gives up
+// instead, since the server won't answer sooner. The last error is returned.
+func (p RetryPolicy) Do(ctx context.Context, operation func() error) error {
+	p = p.withDefaults()
+
+	for attempt := 1; ; attempt++ {
+		err := operation()
+		if err == nil || attempt >= p.MaxAttempts || ctx.Err() != nil || !IsRetryable(err) {
+			return err
+		}
+
+		delay := p.backoff(attempt)
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && httpErr.RetryAfter > 0 {
+			if httpErr.RetryAfter > p.MaxBackoff {
+				return err
+			}
+			delay = httpErr.RetryAfter
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the delay before retrying after attempt: the exponential
+// backoff capped at MaxBackoff, of which the upper half is randomized.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.InitialBackoff << (attempt - 1)
+	if delay <= 0 || delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// HTTPError is an unsuccessful HTTP response from an external API.
+type HTTPError struct {
+	StatusCode int
+	// RetryAfter is the delay requested by the server, or zero.
+	RetryAfter time.Duration
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("HTTP %d", e.StatusCode)
+	}
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Body)
+}
+
+// maxErrorBodySize caps how much of an error response is kept in HTTPError.
+const maxErrorBodySize = 4 << 10
+
+// statusTransport turns 4xx and 5xx responses into *HTTPError so errors are
+// classified the same way whether or not the caller reads the status code.
+// The Places and Firecrawl SDKs don't expose it.
+type statusTransport struct {
+	base http.RoundTripper
+}
+
+func (t statusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode < 400 {
+		return resp, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	return nil, &HTTPError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		Body:       strings.TrimSpace(string(body)),
+	}
+}
+
+// newHTTPClient returns the client used for every external API call.
+func newHTTPClient() *http.Client {
+	return &http.Client{Transport: statusTransport{base: http.DefaultTransport}}
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as
+// an HTTP date. It returns zero when the header is missing or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// retryableMapsStatuses are Places API statuses worth retrying. The SDK
+// reports them as "maps: <STATUS> - <message>".
+var retryableMapsStatuses = []string{"OVER_QUERY_LIMIT", "UNKNOWN_ERROR"}
+
+// IsRetryable reports whether err is a transient failure: a 408, 429 or 5xx
+// response, a timeout or a dropped connection. Giving up on a rate limit and
+// cancellation are permanent.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, ErrRateLimited) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return retryableStatus(httpErr.StatusCode)
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return retryableStatus(apiErr.Code)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+
+	for _, status := range retryableMapsStatuses {
+		if strings.Contains(err.Error(), "maps: "+status) {
+			return true
+		}
+	}
+	return false
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+var fastRetry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+
+func TestRetryPolicyDo(t *testing.T) {
+	tests := []struct {
+		name         string
+		errs         []error
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"success", nil, 1, false},
+		{"transient then success", []error{&HTTPError{StatusCode: 503}}, 2, false},
+		{"permanent", []error{&HTTPError{StatusCode: 404}}, 1, true},
+		{"exhausted", []error{&HTTPError{StatusCode: 500}, &HTTPError{StatusCode: 502}, &HTTPError{StatusCode: 429}}, 3, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := fastRetry.Do(context.Background(), func() error {
+				attempts++
+				if attempts <= len(tt.errs) {
+					return tt.errs[attempts-1]
+				}
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Do() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDoStopsOnContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour, MaxBackoff: time.Hour}
+
+	attempts := 0
+	done := make(chan error)
+	go func() {
+		done <- policy.Do(ctx, func() error {
+			attempts++
+			return &HTTPError{StatusCode: 503}
+		})
+	}()
+	cancel()
+
+	select {
+	case err := <-done:
+		if err == nil || attempts != 1 {
+			t.Errorf("Do() = %v after %d attempts, want the first error", err, attempts)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Do() kept waiting after the context was cancelled")
+	}
+}
+
+func TestRetryPolicyHonorsRetryAfter(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer srv.Close()
+
+	client := newHTTPClient()
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Second}
+	start := time.Now()
+	err := policy.Do(context.Background(), func() error {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Do() = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want the 1s Retry-After", elapsed)
+	}
+}
+
+func TestRetryPolicyGivesUpOnLongRetryAfter(t *testing.T) {
+	attempts := 0
+	start := time.Now()
+	err := fastRetry.Do(context.Background(), func() error {
+		attempts++
+		return &HTTPError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Hour}
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("Do() = %v after %d attempts, want the first error", err, attempts)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Do() waited %v for a Retry-After past MaxBackoff", elapsed)
+	}
+}
+
+func TestBackoffIsBounded(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempt := 1; attempt <= 40; attempt++ {
+		want := policy.InitialBackoff << (attempt - 1)
+		if want <= 0 || want > policy.MaxBackoff {
+			want = policy.MaxBackoff
+		}
+		if got := policy.backoff(attempt); got < want/2 || got > want {
+			t.Errorf("backoff(%d) = %v, want within [%v, %v]", attempt, got, want/2, want)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"", 0},
+		{"30", 30 * time.Second},
+		{"-1", 0},
+		{"Sat, 01 Jun 2024 12:00:10 GMT", 10 * time.Second},
+		{"Sat, 01 Jun 2024 11:00:00 GMT", 0},
+		{"soon", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.in, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"429", &HTTPError{StatusCode: 429}, true},
+		{"503 wrapped", fmt.Errorf("failed to scrape website: %w", &HTTPError{StatusCode: 503}), true},
+		{"400", &HTTPError{StatusCode: 400}, false},
+		{"gcs 500", &googleapi.Error{Code: 500}, true},
+		{"gcs 403", &googleapi.Error{Code: 403}, false},
+		{"timeout", context.DeadlineExceeded, true},
+		{"cancelled", context.Canceled, false},
+		{"rate limit given up", fmt.Errorf("%w: %w", ErrRateLimited, context.DeadlineExceeded), false},
+		{"places quota", errors.New("maps: OVER_QUERY_LIMIT - slow down"), true},
+		{"places denied", errors.New("maps: REQUEST_DENIED - bad key"), false},
+		{"not found", ErrObjectNotFound, false},
+	}
+
+	for _, tt := range tests {
+		if got := IsRetryable(tt.err); got != tt.want {
+			t.Errorf("IsRetryable(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
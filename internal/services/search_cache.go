@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Defaults of CacheConfig.
+const (
+	defaultSearchCacheTTL = 24 * time.Hour
+	defaultSiteCacheTTL   = 24 * time.Hour
+	defaultPageCacheTTL   = 7 * 24 * time.Hour
+)
+
+// CacheConfig sets how long a CompetitorService reuses earlier work. Zero
+// fields use the defaults.
+type CacheConfig struct {
+	// SearchTTL is how long stored search results answer new searches of
+	// the same area; 24h by default.
+	SearchTTL time.Duration
+	// SiteTTL is how long the pages found on a competitor's website, and the
+	// content hashes of those pages, are trusted without asking Firecrawl;
+	// 24h by default.
+	SiteTTL time.Duration
+	// PageTTL is how long products extracted from a page are reused while
+	// its content hash stays the same; 7 days by default.
+	PageTTL time.Duration
+}
+
+func (c CacheConfig) withDefaults() CacheConfig {
+	if c.SearchTTL <= 0 {
+		c.SearchTTL = defaultSearchCacheTTL
+	}
+	if c.SiteTTL <= 0 {
+		c.SiteTTL = defaultSiteCacheTTL
+	}
+	if c.PageTTL <= 0 {
+		c.PageTTL = defaultPageCacheTTL
+	}
+	return c
+}
+
+// Freshness controls whether a search may be answered from the stored
+// result of an earlier search of the same area.
+type Freshness struct {
+	// MaxAge is the oldest stored result accepted. Zero means the cache TTL
+	// of the service; a longer MaxAge accepts data past the TTL.
+	MaxAge time.Duration
+	// Refresh always runs a new search. Competitor websites are still read
+	// from the site cache, which only scrapes pages that changed or expired.
+	Refresh bool
+}
+
+// cachedResult returns the stored result for canonical if it was collected
+// for the same area no longer than the accepted age ago, and nil otherwise.
+// Results without competitors, or missing some that failed, may come from an
+// outage and are searched again. Stored results are scoped to the account
+// ctx carries like any other location data.
+func (s *CompetitorService) cachedResult(ctx context.Context, canonical *CanonicalLocation, area SearchArea, freshness Freshness) *Location {
+	if freshness.Refresh {
+		return nil
+	}
+	maxAge := freshness.MaxAge
+	if maxAge <= 0 {
+		maxAge = s.cache.SearchTTL
+	}
+
+	location, err := s.store.GetLocation(ctx, canonical.Key)
+	if err != nil {
+		if !errors.Is(err, ErrObjectNotFound) {
+			s.logger.Printf("Error reading cached search results for %s: %v", canonical.Key, err)
+		}
+		return nil
+	}
+
+	// Snapshots stored before areas were recorded come from text searches
+	stored := SearchArea{Location: location.Name}
+	if location.Area != nil {
+		stored = *location.Area
+	}
+	if !stored.sameCircle(area) || s.now().Sub(location.UpdatedAt) > maxAge {
+		return nil
+	}
+	if len(location.Competitors) == 0 || location.Failed > 0 {
+		return nil
+	}
+	return location
+}
+
+// sameCircle reports whether a and b search the same circle. Areas without a
+// center are searched by the canonical location alone, so any two of them
+// resolving to the same location match.
+func (a SearchArea) sameCircle(b SearchArea) bool {
+	if a.Center == nil || b.Center == nil {
+		return a.Center == nil && b.Center == nil
+	}
+	return *a.Center == *b.Center && a.radius() == b.radius()
+}
+
+// radius is RadiusMeters with the default applied.
+func (a SearchArea) radius() uint {
+	if a.RadiusMeters == 0 {
+		return defaultSearchRadius
+	}
+	return a.RadiusMeters
+}
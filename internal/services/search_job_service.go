@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// JobStatus is the lifecycle state of a search job.
+type JobStatus string
+
+const (
+	JobStatusQueued    JobStatus = "queued"
+	JobStatusRunning   JobStatus = "running"
+	JobStatusCompleted JobStatus = "completed"
+	JobStatusFailed    JobStatus = "failed"
+)
+
+// ErrQueueFull is returned (wrapped) by Submit when every slot of the search
+// queue is taken. The search can be submitted again later.
+var ErrQueueFull = errors.New("search queue is full")
+
+// finishedJobTTL is how long finished jobs are kept for retrieval.
+const finishedJobTTL = time.Hour
+
+// SearchJob tracks an asynchronous competitor search.
+type SearchJob struct {
+	ID          string                  `json:"id"`
+	Location    string                  `json:"location"`
+	Area        SearchArea              `json:"area"`
+	Filter      CompetitorFilter        `json:"filter"`
+	Freshness   Freshness               `json:"-"`
+	Status      JobStatus               `json:"status"`
+	Competitors []CompetitorProgress    `json:"competitors"`
+	Result      *CompetitorSearchResult `json:"result,omitempty"`
+	Error       string                  `json:"error,omitempty"`
+	CreatedAt   time.Time               `json:"createdAt"`
+	StartedAt   *time.Time              `json:"startedAt,omitempty"`
+	CompletedAt *time.Time              `json:"completedAt,omitempty"`
+
+	// account is the account the job was submitted for, if any. Only it can
+	// see the job and the result is stored under it.
+	account *Account
+	// quota meters the search like the request that submitted it.
+	quota QuotaFunc
+
+	// progress is keyed by place ID; order preserves the order places were
+	// first reported in so the JSON output is stable.
+	progress map[string]CompetitorProgress
+	order    []string
+}
+
+// SearchJobService runs competitor searches on a fixed pool of workers and
+// keeps the finished results in memory for finishedJobTTL.
+type SearchJobService struct {
+	competitors *CompetitorService
+	logger      *log.Logger
+	now         func() time.Time
+
+	mu   sync.RWMutex
+	jobs map[string]*SearchJob
+
+	queue  chan string
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewSearchJobService(competitors *CompetitorService, workers, queueSize int, logger *log.Logger) *SearchJobService {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 1 {
+		queueSize = 100
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	js := &SearchJobService{
+		competitors: competitors,
+		logger:      logger,
+		now:         time.Now,
+		jobs:        make(map[string]*SearchJob),
+		queue:       make(chan string, queueSize),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+
+	for i := 0; i < workers; i++ {
+		js.wg.Add(1)
+		go js.worker()
+	}
+
+	return js
+}
+
+// Submit queues a new search of area for the account ctx carries, metered by
+// its quota, and returns a snapshot of the job. The whole area is searched
+// and stored; filter only narrows the job's result. freshness is passed on
+// to SearchCompetitors.
+func (js *SearchJobService) Submit(ctx context.Context, area SearchArea, filter CompetitorFilter, freshness Freshness) (*SearchJob, error) {
+	id, err := newJobID()
+	if err != nil {
+		return nil, fmt.Errorf("error generating job id: %v", err)
+	}
+
+	job := &SearchJob{
+		ID:        id,
+		Location:  area.Name(),
+		Area:      area,
+		Filter:    filter,
+		Freshness: freshness,
+		Status:    JobStatusQueued,
+		CreatedAt: js.now().UTC(),
+		progress:  make(map[string]CompetitorProgress),
+	}
+	if account, ok := AccountFromContext(ctx); ok {
+		job.account = &account
+	}
+	if quota, ok := QuotaFromContext(ctx); ok {
+		job.quota = quota
+	}
+
+	js.mu.Lock()
+	js.pruneFinished()
+	js.jobs[id] = job
+	js.mu.Unlock()
+
+	select {
+	case js.queue <- id:
+	default:
+		js.mu.Lock()
+		delete(js.jobs, id)
+		js.mu.Unlock()
+		return nil, fmt.Errorf("search of %s: %w", area, ErrQueueFull)
+	}
+
+	js.logger.Printf("Search job %s queued for %s", id, area)
+	snapshot, _ := js.Get(ctx, id)
+	return snapshot, nil
+}
+
+// Get returns a snapshot of the job with the given ID if it was submitted for
+// the account ctx carries.
+func (js *SearchJobService) Get(ctx context.Context, id string) (*SearchJob, bool) {
+	js.mu.RLock()
+	defer js.mu.RUnlock()
+
+	job, ok := js.jobs[id]
+	if !ok || js.expired(job) {
+		return nil, false
+	}
+	if job.scope() != accountScope(ctx) {
+		return nil, false
+	}
+	return job.snapshot(), true
+}
+
+// pruneFinished drops the jobs that finished over finishedJobTTL ago. New
+// jobs are the only thing adding to the map, so Submit calls it to keep the
+// map bounded. js.mu must be held for writing.
+func (js *SearchJobService) pruneFinished() {
+	for id, job := range js.jobs {
+		if js.expired(job) {
+			delete(js.jobs, id)
+		}
+	}
+}
+
+// expired reports whether job finished over finishedJobTTL ago.
+func (js *SearchJobService) expired(job *SearchJob) bool {
+	return job.CompletedAt != nil && js.now().Sub(*job.CompletedAt) > finishedJobTTL
+}
+
+// Close stops accepting work and waits for running jobs to return.
+func (js *SearchJobService) Close() {
+	js.cancel()
+	js.wg.Wait()
+}
+
+func (js *SearchJobService) worker() {
+	defer js.wg.Done()
+
+	for {
+		select {
+		case <-js.ctx.Done():
+			return
+		case id := <-js.queue:
+			js.run(id)
+		}
+	}
+}
+
+func (js *SearchJobService) run(id string) {
+	js.mu.Lock()
+	job, ok := js.jobs[id]
+	if !ok {
+		js.mu.Unlock()
+		return
+	}
+	started := js.now().UTC()
+	job.Status = JobStatusRunning
+	job.StartedAt = &started
+	area, filter, freshness := job.Area, job.Filter, job.Freshness
+	ctx := js.ctx
+	if job.account != nil {
+		ctx = ContextWithAccount(ctx, *job.account)
+	}
+	if job.quota != nil {
+		ctx = ContextWithQuota(ctx, job.quota)
+	}
+	js.mu.Unlock()
+
+	js.logger.Printf("Search job %s started for %s", id, area)
+	result, err := js.competitors.SearchCompetitorsWithProgress(ctx, area, freshness, func(progress CompetitorProgress) {
+		js.mu.Lock()
+		defer js.mu.Unlock()
+		job.setProgress(progress)
+	})
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	completed := js.now().UTC()
+	job.CompletedAt = &completed
+	if err != nil {
+		job.Status = JobStatusFailed
+		job.Error = err.Error()
+		js.logger.Printf("Search job %s failed: %v", id, err)
+		return
+	}
+	job.Status = JobStatusCompleted
+	job.Result = result.Filter(filter)
+	js.logger.Printf("Search job %s completed with %d competitors", id, job.Result.TotalFound)
+}
+
+// scope is the storage scope of the job's account, as in accountScope.
+func (j *SearchJob) scope() string {
+	if j.account == nil {
+		return ""
+	}
+	return accountID(*j.account)
+}
+
+func (j *SearchJob) setProgress(progress CompetitorProgress) {
+	if _, ok := j.progress[progress.PlaceID]; !ok {
+		j.order = append(j.order, progress.PlaceID)
+	}
+	j.progress[progress.PlaceID] = progress
+}
+
+func (j *SearchJob) snapshot() *SearchJob {
+	out := *j
+	out.Competitors = make([]CompetitorProgress, 0, len(j.order))
+	for _, placeID := range j.order {
+		out.Competitors = append(out.Competitors, j.progress[placeID])
+	}
+	out.progress = nil
+	out.order = nil
+	return &out
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
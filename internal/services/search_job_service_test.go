@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSearchJobServiceQueue(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	// No workers run, so submitted jobs stay queued
+	js := &SearchJobService{
+		logger: testLogger(),
+		now:    func() time.Time { return now },
+		jobs:   make(map[string]*SearchJob),
+		queue:  make(chan string, 1),
+	}
+	ctx := context.Background()
+	area := SearchArea{Location: "Austin"}
+
+	queued, err := js.Submit(ctx, area, CompetitorFilter{}, Freshness{})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	if _, err := js.Submit(ctx, area, CompetitorFilter{}, Freshness{}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Submit to a full queue error = %v, want ErrQueueFull", err)
+	}
+	<-js.queue
+
+	// Finished jobs are kept for finishedJobTTL, unfinished ones until they
+	// finish
+	completed := now
+	js.jobs["done"] = &SearchJob{ID: "done", Status: JobStatusCompleted, CompletedAt: &completed}
+	now = now.Add(finishedJobTTL)
+	if _, ok := js.Get(ctx, "done"); !ok {
+		t.Error("job finished finishedJobTTL ago is gone")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := js.Get(ctx, "done"); ok {
+		t.Error("Get returned an expired job")
+	}
+	if _, err := js.Submit(ctx, area, CompetitorFilter{}, Freshness{}); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	if _, ok := js.jobs["done"]; ok {
+		t.Error("Submit kept the expired job")
+	}
+	if _, ok := js.Get(ctx, queued.ID); !ok {
+		t.Error("Submit dropped a queued job")
+	}
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mendableai/firecrawl-go"
+)
+
+// SiteMap is the cached map of a competitor's website: the product pages
+// found on it by a Firecrawl map, or by a crawl when the map finds none.
+type SiteMap struct {
+	Website string   `json:"website"`
+	Pages   []string `json:"pages"`
+	// Crawled is set when the pages were found by a crawl.
+	Crawled   bool      `json:"crawled,omitempty"`
+	FetchedAt time.Time `json:"fetchedAt"`
+}
+
+// ScrapedPage is the cached scrape of one product page. FetchedAt is when its
+// products were extracted and CheckedAt when ContentHash was last confirmed
+// to match the live page.
+type ScrapedPage struct {
+	URL         string    `json:"url"`
+	Products    []Product `json:"products"`
+	ContentHash string    `json:"contentHash,omitempty"`
+	FetchedAt   time.Time `json:"fetchedAt"`
+	CheckedAt   time.Time `json:"checkedAt"`
+}
+
+// siteMap returns the product pages of website, from the site cache while
+// they were mapped within the site TTL and from Firecrawl otherwise.
+func (s *CompetitorService) siteMap(ctx context.Context, website string) (*SiteMap, error) {
+	cached, err := s.store.GetSiteMap(ctx, website)
+	switch {
+	case err == nil && s.now().Sub(cached.FetchedAt) < s.cache.SiteTTL:
+		s.logger.Printf("Using %d pages of website %s mapped at %s", len(cached.Pages), website, cached.FetchedAt.Format(time.RFC3339))
+		return cached, nil
+	case err != nil && !errors.Is(err, ErrObjectNotFound):
+		s.logger.Printf("Error reading cached map of website %s: %v", website, err)
+	}
+
+	siteMap, err := s.mapSite(ctx, website)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.StoreSiteMap(ctx, *siteMap); err != nil {
+		s.logger.Printf("Error caching map of website %s: %v", website, err)
+	}
+	return siteMap, nil
+}
+
+// mapSite finds the product pages of website with a Firecrawl map, falling
+// back to a crawl when the map finds none.
+func (s *CompetitorService) mapSite(ctx context.Context, website string) (*SiteMap, error) {
+	siteMap := &SiteMap{Website: website, FetchedAt: s.now().UTC()}
+
+	// First try to map the website
+	s.logger.Printf("Mapping website: %s", website)
+	mapResponse, err := s.firecrawl.MapWebsite(ctx, website)
+	if err != nil {
+		s.logger.Printf("Error mapping website %s: %v", website, err)
+		// Continue with crawl as fallback
+	}
+
+	if mapResponse != nil && mapResponse.Links != nil {
+		s.logger.Printf("Found %d links from mapping for website %s", len(mapResponse.Links), website)
+		siteMap.Pages = filterRelevantURLs(mapResponse.Links)
+	}
+	if len(siteMap.Pages) > 0 {
+		return siteMap, nil
+	}
+
+	// If no relevant URLs found through mapping, try crawling
+	s.logger.Printf("No relevant URLs found for website %s, falling back to crawl", website)
+	crawlResponse, err := s.firecrawl.CrawlWebsite(ctx, website, &firecrawl.ScrapeParams{Formats: []string{"links"}}, 500)
+	if err != nil {
+		s.logger.Printf("Error initiating crawl for website %s: %v", website, err)
+		return nil, err
+	}
+
+	crawlID := crawlResponse.ID
+	s.logger.Printf("Crawl initiated for website %s with ID %s", website, crawlID)
+	statusResponse, err := s.firecrawl.WaitForCrawl(ctx, crawlID)
+	if err != nil {
+		s.logger.Printf("Error waiting for crawl ID %s: %v", crawlID, err)
+		return nil, err
+	}
+
+	// Collect page URLs and links from each FirecrawlDocument
+	var links []string
+	for _, doc := range statusResponse.Data {
+		if doc == nil {
+			continue
+		}
+		if doc.Metadata != nil && doc.Metadata.SourceURL != nil {
+			links = append(links, *doc.Metadata.SourceURL)
+		}
+		links = append(links, doc.Links...)
+	}
+	s.logger.Printf("Crawl completed for website %s, found %d links", website, len(links))
+	siteMap.Pages = filterRelevantURLs(links)
+	siteMap.Crawled = true
+	return siteMap, nil
+}
+
+// scrapedPage returns the products on pageURL, one of the pages of website.
+// A cached page is reused as is while its content hash was confirmed within
+// the site TTL. Past that its hash is checked against the live page, and its
+// products are only extracted again if the page changed or they are older
+// than the page TTL.
+func (s *CompetitorService) scrapedPage(ctx context.Context, website, pageURL string) (*ScrapedPage, error) {
+	now := s.now().UTC()
+
+	cached, err := s.store.GetPage(ctx, website, pageURL)
+	if err != nil {
+		if !errors.Is(err, ErrObjectNotFound) {
+			s.logger.Printf("Error reading cached page %s: %v", pageURL, err)
+		}
+		cached = nil
+	}
+	if cached != nil && now.Sub(cached.FetchedAt) >= s.cache.PageTTL {
+		cached = nil
+	}
+
+	if cached != nil {
+		if now.Sub(cached.CheckedAt) < s.cache.SiteTTL {
+			return cached, nil
+		}
+
+		hash, err := s.firecrawl.PageContentHash(ctx, pageURL)
+		switch {
+		case err != nil:
+			s.logger.Printf("Error checking page %s for changes: %v", pageURL, err)
+		case hash != "" && hash == cached.ContentHash:
+			s.logger.Printf("Page %s is unchanged since %s", pageURL, cached.FetchedAt.Format(time.RFC3339))
+			cached.CheckedAt = now
+			s.storePage(ctx, website, *cached)
+			return cached, nil
+		default:
+			s.logger.Printf("Page %s changed since %s", pageURL, cached.FetchedAt.Format(time.RFC3339))
+		}
+	}
+
+	s.logger.Printf("Extracting products from URL: %s", pageURL)
+	page, err := s.firecrawl.ScrapePage(ctx, pageURL)
+	if err != nil {
+		// Products that haven't expired are better than none
+		if cached != nil {
+			s.logger.Printf("Error extracting products from %s, using those from %s: %v", pageURL, cached.FetchedAt.Format(time.RFC3339), err)
+			return cached, nil
+		}
+		return nil, err
+	}
+	page.FetchedAt = now
+	page.CheckedAt = now
+	s.storePage(ctx, website, *page)
+	return page, nil
+}
+
+// storePage caches page, logging failures since the page can be scraped
+// again.
+func (s *CompetitorService) storePage(ctx context.Context, website string, page ScrapedPage) {
+	if err := s.store.StorePage(ctx, website, page); err != nil {
+		s.logger.Printf("Error caching page %s: %v", page.URL, err)
+	}
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProcessCompetitorRefreshesChangedPages(t *testing.T) {
+	var (
+		mu          sync.Mutex
+		mapRequests int
+		checks      = make(map[string]int)
+		extractions = make(map[string]int)
+		prices      = map[string]string{"/rentals": "$150", "/inventory": "$250"}
+	)
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var request struct {
+			URL     string   `json:"url"`
+			Formats []string `json:"formats"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+
+		switch r.URL.Path {
+		case "/v1/map":
+			mapRequests++
+			json.NewEncoder(w).Encode(map[string]any{
+				"success": true,
+				"links":   []string{srv.URL + "/about", srv.URL + "/rentals", srv.URL + "/inventory"},
+			})
+		case "/v1/scrape":
+			path := request.URL[len(srv.URL):]
+			data := map[string]any{"markdown": "Castle " + prices[path]}
+			if slices.Contains(request.Formats, "extract") {
+				extractions[path]++
+				data["extract"] = map[string]any{"products": []map[string]any{{"name": "Castle" + path, "price": prices[path]}}}
+			} else {
+				checks[path]++
+			}
+			json.NewEncoder(w).Encode(map[string]any{"success": true, "data": data})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	limits := NewRateLimits(RateLimitConfig{
+		Firecrawl: RateBudget{Requests: 100, Per: time.Second},
+		Domain:    RateBudget{Requests: 100, Per: time.Second},
+	})
+	fc, err := NewFirecrawlClient("test-key", srv.URL+"/v1/", 0, limits, RetryPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := log.New(io.Discard, "", 0)
+	cache := CacheConfig{SiteTTL: time.Hour, PageTTL: 24 * time.Hour}
+	s := NewCompetitorService(fc, nil, nil, NewMemoryStore(logger), cache, logger)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	process := func() *Competitor {
+		t.Helper()
+		competitor, err := s.processCompetitor(ctx, Place{PlaceID: "p1", Name: "Jumpers", Website: srv.URL})
+		if err != nil || competitor == nil {
+			t.Fatalf("processCompetitor = %v, %v", competitor, err)
+		}
+		return competitor
+	}
+	counts := func() (int, map[string]int, map[string]int) {
+		mu.Lock()
+		defer mu.Unlock()
+		return mapRequests, maps.Clone(checks), maps.Clone(extractions)
+	}
+
+	process()
+	if m, c, e := counts(); m != 1 || len(c) != 0 || e["/rentals"] != 1 || e["/inventory"] != 1 {
+		t.Fatalf("first run: maps = %d, checks = %v, extractions = %v", m, c, e)
+	}
+
+	// Within the site TTL nothing is requested again
+	now = now.Add(30 * time.Minute)
+	process()
+	if m, c, e := counts(); m != 1 || len(c) != 0 || len(e) != 2 || e["/rentals"] != 1 {
+		t.Errorf("cached run: maps = %d, checks = %v, extractions = %v", m, c, e)
+	}
+
+	// Past it the site is mapped again and only the changed page extracted
+	mu.Lock()
+	prices["/inventory"] = "$275"
+	mu.Unlock()
+	now = now.Add(time.Hour)
+	competitor := process()
+	if m, c, e := counts(); m != 2 || c["/rentals"] != 1 || c["/inventory"] != 1 || e["/rentals"] != 1 || e["/inventory"] != 2 {
+		t.Errorf("refresh: maps = %d, checks = %v, extractions = %v", m, c, e)
+	}
+	if len(competitor.Products) != 2 || competitor.Products[1].Price.Min != 275 {
+		t.Errorf("products = %+v, want the new inventory price", competitor.Products)
+	}
+
+	// Unchanged pages are extracted again once they expire
+	now = now.Add(24 * time.Hour)
+	process()
+	if _, _, e := counts(); e["/rentals"] != 2 || e["/inventory"] != 3 {
+		t.Errorf("expired: extractions = %v, want every page again", e)
+	}
+}
@@ -0,0 +1,524 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// ErrObjectNotFound is returned (wrapped) when a requested object does not
+// exist in the backing store.
+var ErrObjectNotFound = errors.New("object not found")
+
+// errGenerationMismatch is returned (wrapped) by conditional writes when the
+// object changed since it was read.
+var errGenerationMismatch = errors.New("object generation changed")
+
+// maxUpdateAttempts bounds how often objectStore.update rereads an object
+// that keeps changing under it.
+const maxUpdateAttempts = 10
+
+// snapshotVersionFormat sorts lexically in time order so older snapshots can
+// be found with a plain string comparison.
+const snapshotVersionFormat = "20060102T150405.000000000Z"
+
+// Location is the stored search result for a canonical location. Key names
+// its objects; Name is only for display. Area is the search the competitors
+// were collected by, and Failed how many competitors it couldn't collect.
+type Location struct {
+	Key         string             `json:"key"`
+	Name        string             `json:"name"`
+	Canonical   *CanonicalLocation `json:"canonical,omitempty"`
+	Area        *SearchArea        `json:"area,omitempty"`
+	Version     string             `json:"version,omitempty"`
+	UpdatedAt   time.Time          `json:"updatedAt"`
+	Competitors []Competitor       `json:"competitors"`
+	Failed      int                `json:"failed,omitempty"`
+}
+
+// ObjectAttrs describes a stored object.
+type ObjectAttrs struct {
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"contentType"`
+	Updated     time.Time `json:"updated"`
+}
+
+// Store is the persistence API used by the services. Every backend must pass
+// the conformance suite in store_test.go.
+type Store interface {
+	// PutObject streams r into objectName. Only readers that are also
+	// io.Seekers are retried.
+	PutObject(ctx context.Context, objectName, contentType string, r io.Reader) error
+	StatObject(ctx context.Context, objectName string) (*ObjectAttrs, error)
+	// OpenObject reads length bytes of objectName starting at offset, or up
+	// to its end if length is negative.
+	OpenObject(ctx context.Context, objectName string, offset, length int64) (io.ReadCloser, error)
+	// The location methods read and write the data of the account ctx
+	// carries (see ContextWithAccount).
+	StoreLocation(ctx context.Context, location Location) error
+	// StoreCompetitor and StoreProduct write into snapshot version of a
+	// location.
+	StoreCompetitor(ctx context.Context, locationKey, version string, competitor Competitor) error
+	StoreProduct(ctx context.Context, locationKey, version string, competitor Competitor, product Product) error
+	StoreSnapshot(ctx context.Context, location Location) error
+	GetLocation(ctx context.Context, locationKey string) (*Location, error)
+	// StoreAlias records that alias, a slug of a location as a caller wrote
+	// it, resolves to location.
+	StoreAlias(ctx context.Context, alias string, location CanonicalLocation) error
+	GetAlias(ctx context.Context, alias string) (*CanonicalLocation, error)
+	StoreAPIKey(ctx context.Context, key APIKey) error
+	GetAPIKey(ctx context.Context, id string) (*APIKey, error)
+	// UpdateAPIKey applies update to the stored key and writes it back
+	// unless another writer changed the key in between, in which case
+	// update runs again on a fresh copy. Nothing is written if update
+	// fails.
+	UpdateAPIKey(ctx context.Context, id string, update func(key *APIKey) error) (*APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	// The site methods cache what was scraped from a competitor's website,
+	// keyed by its domain. Like aliases, sites are shared by all accounts.
+	StoreSiteMap(ctx context.Context, siteMap SiteMap) error
+	GetSiteMap(ctx context.Context, website string) (*SiteMap, error)
+	StorePage(ctx context.Context, website string, page ScrapedPage) error
+	GetPage(ctx context.Context, website, pageURL string) (*ScrapedPage, error)
+	// Close releases the clients held by the store.
+	Close() error
+}
+
+// Storage backends selectable through StoreConfig.Backend.
+const (
+	StorageBackendGCS    = "gcs"
+	StorageBackendLocal  = "local"
+	StorageBackendMemory = "memory"
+)
+
+type StoreConfig struct {
+	Backend             string
+	CredentialsFilePath string
+	BucketName          string
+	LocalDir            string
+	// Retry applies to GCS requests; local and in-memory stores never fail
+	// transiently.
+	Retry RetryPolicy
+}
+
+// NewStore builds the Store selected by cfg.Backend, defaulting to GCS.
+func NewStore(cfg StoreConfig, logger *log.Logger) (Store, error) {
+	switch cfg.Backend {
+	case "", StorageBackendGCS:
+		return NewFirebaseService(cfg.CredentialsFilePath, cfg.BucketName, cfg.Retry, logger)
+	case StorageBackendLocal:
+		return NewLocalStore(cfg.LocalDir, logger)
+	case StorageBackendMemory:
+		return NewMemoryStore(logger), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
+
+// objectBackend is the blob API a storage backend has to provide. Object
+// names always use "/" as separator.
+type objectBackend interface {
+	// newWriter returns a writer for name. The object only becomes visible
+	// once Close returns without error; cancelling ctx before Close discards
+	// it.
+	newWriter(ctx context.Context, name, contentType string) io.WriteCloser
+	// newWriterIf is newWriter for a compare-and-swap: Close fails with an
+	// error wrapping errGenerationMismatch unless name is still at
+	// generation, where 0 means it must not exist.
+	newWriterIf(ctx context.Context, name, contentType string, generation int64) io.WriteCloser
+	// readGeneration reads all of name along with its generation, which
+	// changes whenever name is written.
+	readGeneration(ctx context.Context, name string) ([]byte, int64, error)
+	// newReader, newRangeReader, readGeneration and stat return an error
+	// wrapping ErrObjectNotFound if name doesn't exist.
+	newReader(ctx context.Context, name string) (io.ReadCloser, error)
+	// newRangeReader reads length bytes from offset, or up to the end if
+	// length is negative.
+	newRangeReader(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error)
+	stat(ctx context.Context, name string) (ObjectAttrs, error)
+	list(ctx context.Context, prefix string) ([]string, error)
+	delete(ctx context.Context, name string) error
+}
+
+// objectStore implements Store on top of an objectBackend so that every
+// backend shares the same object layout.
+type objectStore struct {
+	backend objectBackend
+	retry   RetryPolicy
+	logger  *log.Logger
+}
+
+func newObjectStore(backend objectBackend, retry RetryPolicy, logger *log.Logger) *objectStore {
+	return &objectStore{
+		backend: backend,
+		retry:   retry,
+		logger:  logger,
+	}
+}
+
+// Close is a no-op; stores backed by a client override it.
+func (st *objectStore) Close() error {
+	return nil
+}
+
+func (st *objectStore) PutObject(ctx context.Context, objectName, contentType string, r io.Reader) error {
+	var err error
+	if seeker, ok := r.(io.Seeker); ok {
+		err = st.retry.Do(ctx, func() error {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			return st.put(ctx, objectName, contentType, r)
+		})
+	} else {
+		err = st.put(ctx, objectName, contentType, r)
+	}
+	if err != nil {
+		return fmt.Errorf("error uploading %s to storage: %w", objectName, err)
+	}
+
+	st.logger.Printf("Object %s uploaded", objectName)
+	return nil
+}
+
+// put makes a single attempt at writing r into objectName.
+func (st *objectStore) put(ctx context.Context, objectName, contentType string, r io.Reader) error {
+	return st.write(ctx, st.backend.newWriter, objectName, contentType, r)
+}
+
+// write copies r into the writer newWriter returns for objectName.
+func (st *objectStore) write(ctx context.Context, newWriter func(ctx context.Context, name, contentType string) io.WriteCloser, objectName, contentType string, r io.Reader) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wc := newWriter(ctx, objectName, contentType)
+	if _, err := io.Copy(wc, r); err != nil {
+		// Cancel before closing so the partial object is discarded
+		cancel()
+		wc.Close()
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("error closing writer: %w", err)
+	}
+	return nil
+}
+
+func (st *objectStore) StatObject(ctx context.Context, objectName string) (*ObjectAttrs, error) {
+	var attrs ObjectAttrs
+	err := st.retry.Do(ctx, func() error {
+		var err error
+		attrs, err = st.backend.stat(ctx, objectName)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading attributes of %s: %w", objectName, err)
+	}
+	return &attrs, nil
+}
+
+func (st *objectStore) OpenObject(ctx context.Context, objectName string, offset, length int64) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	err := st.retry.Do(ctx, func() error {
+		var err error
+		rc, err = st.backend.newRangeReader(ctx, objectName, offset, length)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating reader: %w", err)
+	}
+	return rc, nil
+}
+
+func (st *objectStore) StoreLocation(ctx context.Context, location Location) error {
+	if location.Key == "" {
+		return fmt.Errorf("location %q has no key", location.Name)
+	}
+	objectName := locationObjectName(accountScope(ctx), location.Key)
+	if err := st.writeJSON(ctx, objectName, location); err != nil {
+		return fmt.Errorf("error writing location data to storage: %v", err)
+	}
+
+	st.logger.Printf("Location %s stored in %s", location.Key, objectName)
+	return nil
+}
+
+func (st *objectStore) StoreCompetitor(ctx context.Context, locationKey, version string, competitor Competitor) error {
+	objectName := competitorObjectName(accountScope(ctx), locationKey, version, competitor)
+	if err := st.writeJSON(ctx, objectName, competitor); err != nil {
+		return fmt.Errorf("error writing competitor data to storage: %v", err)
+	}
+
+	st.logger.Printf("Competitor %s stored in %s", competitor.Name, objectName)
+	return nil
+}
+
+func (st *objectStore) StoreProduct(ctx context.Context, locationKey, version string, competitor Competitor, product Product) error {
+	objectName := productObjectName(accountScope(ctx), locationKey, version, competitor, product)
+	if err := st.writeJSON(ctx, objectName, product); err != nil {
+		return fmt.Errorf("error writing product data to storage: %v", err)
+	}
+
+	st.logger.Printf("Product %s stored in %s", product.Name, objectName)
+	return nil
+}
+
+func (st *objectStore) GetLocation(ctx context.Context, locationKey string) (*Location, error) {
+	objectName := locationObjectName(accountScope(ctx), locationKey)
+
+	var location Location
+	if err := st.readJSON(ctx, objectName, &location); err != nil {
+		return nil, err
+	}
+
+	st.logger.Printf("Location %s retrieved from %s", locationKey, objectName)
+	return &location, nil
+}
+
+// StoreAlias writes the alias object. Aliases live outside the locations so
+// they survive snapshot pruning.
+func (st *objectStore) StoreAlias(ctx context.Context, alias string, location CanonicalLocation) error {
+	if err := st.writeJSON(ctx, aliasObjectName(alias), location); err != nil {
+		return fmt.Errorf("error writing location alias to storage: %v", err)
+	}
+	return nil
+}
+
+func (st *objectStore) GetAlias(ctx context.Context, alias string) (*CanonicalLocation, error) {
+	var location CanonicalLocation
+	if err := st.readJSON(ctx, aliasObjectName(alias), &location); err != nil {
+		return nil, err
+	}
+	return &location, nil
+}
+
+// StoreAPIKey writes the key object. API keys aren't scoped to an account;
+// they carry the account they act as.
+func (st *objectStore) StoreAPIKey(ctx context.Context, key APIKey) error {
+	if err := st.writeJSON(ctx, apiKeyObjectName(key.ID), key); err != nil {
+		return fmt.Errorf("error writing api key to storage: %v", err)
+	}
+	return nil
+}
+
+func (st *objectStore) GetAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	var key APIKey
+	if err := st.readJSON(ctx, apiKeyObjectName(id), &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (st *objectStore) UpdateAPIKey(ctx context.Context, id string, update func(key *APIKey) error) (*APIKey, error) {
+	var key APIKey
+	err := st.update(ctx, apiKeyObjectName(id), func(data []byte) ([]byte, error) {
+		key = APIKey{}
+		if err := json.Unmarshal(data, &key); err != nil {
+			return nil, err
+		}
+		if err := update(&key); err != nil {
+			return nil, err
+		}
+		return json.Marshal(key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (st *objectStore) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	var names []string
+	err := st.retry.Do(ctx, func() error {
+		var err error
+		names, err = st.backend.list(ctx, apiKeysPrefix())
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing api keys: %v", err)
+	}
+
+	keys := make([]APIKey, 0, len(names))
+	for _, name := range names {
+		var key APIKey
+		if err := st.readJSON(ctx, name, &key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (st *objectStore) StoreSiteMap(ctx context.Context, siteMap SiteMap) error {
+	if err := st.writeJSON(ctx, siteMapObjectName(siteMap.Website), siteMap); err != nil {
+		return fmt.Errorf("error writing site map to storage: %v", err)
+	}
+	return nil
+}
+
+func (st *objectStore) GetSiteMap(ctx context.Context, website string) (*SiteMap, error) {
+	var siteMap SiteMap
+	if err := st.readJSON(ctx, siteMapObjectName(website), &siteMap); err != nil {
+		return nil, err
+	}
+	return &siteMap, nil
+}
+
+// StorePage writes the page object under the site of website, which is
+// usually but not always the domain of the page itself.
+func (st *objectStore) StorePage(ctx context.Context, website string, page ScrapedPage) error {
+	if err := st.writeJSON(ctx, pageObjectName(website, page.URL), page); err != nil {
+		return fmt.Errorf("error writing scraped page to storage: %v", err)
+	}
+	return nil
+}
+
+func (st *objectStore) GetPage(ctx context.Context, website, pageURL string) (*ScrapedPage, error) {
+	var page ScrapedPage
+	if err := st.readJSON(ctx, pageObjectName(website, pageURL), &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// StoreSnapshot writes a complete search result for a location. Competitor and
+// product documents are written under a new snapshot version (see
+// LayoutVersion) and the location document is written last, so readers only
+// ever see a fully written snapshot. Older snapshot versions are deleted
+// afterwards.
+func (st *objectStore) StoreSnapshot(ctx context.Context, location Location) error {
+	now := time.Now().UTC()
+	location.Version = now.Format(snapshotVersionFormat)
+	location.UpdatedAt = now
+
+	if err := st.writeSnapshot(ctx, location); err != nil {
+		return err
+	}
+
+	return st.pruneSnapshots(ctx, location.Key, location.Version)
+}
+
+// writeSnapshot writes location under its current Version, location document
+// last.
+func (st *objectStore) writeSnapshot(ctx context.Context, location Location) error {
+	for _, competitor := range location.Competitors {
+		if err := st.StoreCompetitor(ctx, location.Key, location.Version, competitor); err != nil {
+			return err
+		}
+		for _, product := range competitor.Products {
+			if err := st.StoreProduct(ctx, location.Key, location.Version, competitor, product); err != nil {
+				return err
+			}
+		}
+	}
+
+	return st.StoreLocation(ctx, location)
+}
+
+// pruneSnapshots deletes every snapshot of a location older than version.
+// Newer versions are left alone so concurrent writers can't delete each
+// other's data.
+func (st *objectStore) pruneSnapshots(ctx context.Context, locationKey, version string) error {
+	root := snapshotsPrefix(accountScope(ctx), locationKey)
+	var names []string
+	err := st.retry.Do(ctx, func() error {
+		var err error
+		names, err = st.backend.list(ctx, root)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error listing snapshots: %v", err)
+	}
+
+	for _, name := range names {
+		objectVersion, _, _ := strings.Cut(strings.TrimPrefix(name, root), "/")
+		if objectVersion >= version {
+			continue
+		}
+		err := st.retry.Do(ctx, func() error {
+			return st.backend.delete(ctx, name)
+		})
+		if err != nil && !errors.Is(err, ErrObjectNotFound) {
+			return fmt.Errorf("error deleting stale object %s: %v", name, err)
+		}
+	}
+
+	st.logger.Printf("Pruned snapshots of %s older than %s", locationKey, version)
+	return nil
+}
+
+func (st *objectStore) writeJSON(ctx context.Context, objectName string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error marshaling %s: %v", objectName, err)
+	}
+
+	r := bytes.NewReader(data)
+	return st.retry.Do(ctx, func() error {
+		r.Reset(data)
+		return st.put(ctx, objectName, "application/json", r)
+	})
+}
+
+// update replaces the content of objectName with what apply makes of it.
+// The write only succeeds if the object is still at the generation that was
+// read, so concurrent updates, even from other processes, are never lost:
+// the loser reads the object again and reapplies its change.
+func (st *objectStore) update(ctx context.Context, objectName string, apply func(data []byte) ([]byte, error)) error {
+	for attempt := 1; ; attempt++ {
+		var (
+			data       []byte
+			generation int64
+		)
+		err := st.retry.Do(ctx, func() error {
+			var err error
+			data, generation, err = st.backend.readGeneration(ctx, objectName)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", objectName, err)
+		}
+
+		updated, err := apply(data)
+		if err != nil {
+			return err
+		}
+
+		r := bytes.NewReader(updated)
+		newWriter := func(ctx context.Context, name, contentType string) io.WriteCloser {
+			return st.backend.newWriterIf(ctx, name, contentType, generation)
+		}
+		err = st.retry.Do(ctx, func() error {
+			r.Reset(updated)
+			return st.write(ctx, newWriter, objectName, "application/json", r)
+		})
+		if !errors.Is(err, errGenerationMismatch) {
+			return err
+		}
+		if attempt == maxUpdateAttempts {
+			return fmt.Errorf("error updating %s after %d attempts: %w", objectName, attempt, err)
+		}
+	}
+}
+
+func (st *objectStore) readJSON(ctx context.Context, objectName string, v any) error {
+	return st.retry.Do(ctx, func() error {
+		rc, err := st.backend.newReader(ctx, objectName)
+		if err != nil {
+			return fmt.Errorf("error creating reader: %w", err)
+		}
+		defer rc.Close()
+
+		if err := json.NewDecoder(rc).Decode(v); err != nil {
+			return fmt.Errorf("error decoding %s: %w", objectName, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,280 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Migrator rewrites objects stored in the unversioned bucket layout into the
+// current one (see LayoutVersion). Every Store built by NewStore implements
+// it.
+type Migrator interface {
+	MigrateLayout(ctx context.Context, opts MigrationOptions) (*MigrationReport, error)
+}
+
+// MigrationOptions control MigrateLayout.
+type MigrationOptions struct {
+	// Resolve maps the name of a location stored before locations had keys
+	// to its canonical location. Such locations are skipped when it is nil.
+	Resolve func(ctx context.Context, name string) (*CanonicalLocation, error)
+	// DryRun reports what would be migrated without writing anything.
+	DryRun bool
+	// DeleteLegacy deletes the legacy objects of every location and alias
+	// once it has been rewritten.
+	DeleteLegacy bool
+	// Account receives the migrated locations. Data stored before
+	// authentication has no owner and isn't visible to any signed-in user;
+	// with Account set, legacy locations and the unscoped locations of the
+	// current layout (v2/locations/...) are written into that account. All
+	// of them go to the one account, so it is meant for deployments that
+	// served a single customer before authentication.
+	Account *Account
+}
+
+// MigrationReport lists what MigrateLayout did.
+type MigrationReport struct {
+	// Locations maps each migrated legacy location prefix to its key.
+	Locations map[string]string `json:"locations"`
+	Aliases   []string          `json:"aliases"`
+	// Skipped maps legacy objects that were left alone to the reason.
+	Skipped map[string]string `json:"skipped"`
+	Deleted int               `json:"deleted"`
+}
+
+const (
+	legacyAliasPrefix    = "aliases/"
+	legacyLocationObject = "location.json"
+)
+
+// MigrateLayout copies every legacy location and alias into the current
+// layout, and the unscoped locations into opts.Account if it is set.
+// Locations keep their snapshot version; when several locations share a key,
+// the newest snapshot wins. Objects outside the legacy layout, such as
+// uploaded files, are not touched. ctx must not carry an account.
+func (st *objectStore) MigrateLayout(ctx context.Context, opts MigrationOptions) (*MigrationReport, error) {
+	// target is where locations are written
+	target := ctx
+	if opts.Account != nil {
+		target = ContextWithAccount(ctx, *opts.Account)
+	}
+
+	var names []string
+	err := st.retry.Do(ctx, func() error {
+		var err error
+		names, err = st.backend.list(ctx, "")
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing objects: %v", err)
+	}
+	sort.Strings(names)
+
+	report := &MigrationReport{
+		Locations: make(map[string]string),
+		Skipped:   make(map[string]string),
+	}
+	var legacy, candidates, unscoped []string
+
+	for _, name := range names {
+		if strings.HasPrefix(name, LayoutVersion+"/") {
+			if key, ok := unscopedLocationKey(name); ok && opts.Account != nil {
+				unscoped = append(unscoped, key)
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(name, legacyAliasPrefix) && strings.HasSuffix(name, ".json"):
+			alias := strings.TrimSuffix(strings.TrimPrefix(name, legacyAliasPrefix), ".json")
+			if err := st.migrateAlias(ctx, name, alias, opts); err != nil {
+				return report, err
+			}
+			report.Aliases = append(report.Aliases, alias)
+			legacy = append(legacy, name)
+
+		case strings.HasSuffix(name, "/"+legacyLocationObject):
+			candidates = append(candidates, name)
+		}
+	}
+
+	// Legacy location names may contain "/", so location documents are
+	// looked for at any depth. Products named "location" end in
+	// "/location.json" too, so a document only counts as a location when it
+	// names its own path.
+	locations := make(map[string]Location)
+	var unnamed []string
+	for _, name := range candidates {
+		var location Location
+		err := st.readJSON(ctx, name, &location)
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+			report.Skipped[name] = fmt.Sprintf("not a location document: %v", err)
+			continue
+		}
+		if err != nil {
+			return report, fmt.Errorf("error reading legacy location %s: %v", name, err)
+		}
+
+		dir := strings.TrimSuffix(name, legacyLocationObject)
+		if location.Name+"/" != dir && location.Key+"/" != dir {
+			unnamed = append(unnamed, name)
+			continue
+		}
+		locations[dir] = location
+	}
+
+	prefixes := make([]string, 0, len(locations))
+	for prefix := range locations {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	for _, name := range unnamed {
+		if legacyOwner(prefixes, name) == "" {
+			report.Skipped[name] = "document doesn't name the location at its path"
+		}
+	}
+
+	for _, prefix := range prefixes {
+		name := prefix + legacyLocationObject
+		key, reason, err := st.migrateLocation(target, name, locations[prefix], opts)
+		if err != nil {
+			return report, err
+		}
+		if reason != "" {
+			report.Skipped[name] = reason
+			continue
+		}
+		report.Locations[prefix] = key
+		for _, object := range names {
+			if legacyOwner(prefixes, object) == prefix {
+				legacy = append(legacy, object)
+			}
+		}
+	}
+
+	for _, key := range unscoped {
+		name := locationObjectName("", key)
+		var location Location
+		if err := st.readJSON(ctx, name, &location); err != nil {
+			return report, fmt.Errorf("error reading unscoped location %s: %v", name, err)
+		}
+		_, reason, err := st.migrateLocation(target, name, location, opts)
+		if err != nil {
+			return report, err
+		}
+		if reason != "" {
+			report.Skipped[name] = reason
+			continue
+		}
+
+		prefix := locationPrefix("", key) + "/"
+		report.Locations[prefix] = key
+		for _, object := range names {
+			if strings.HasPrefix(object, prefix) {
+				legacy = append(legacy, object)
+			}
+		}
+	}
+
+	if opts.DryRun || !opts.DeleteLegacy {
+		return report, nil
+	}
+	for _, name := range legacy {
+		err := st.retry.Do(ctx, func() error {
+			return st.backend.delete(ctx, name)
+		})
+		if err != nil && !errors.Is(err, ErrObjectNotFound) {
+			return report, fmt.Errorf("error deleting legacy object %s: %v", name, err)
+		}
+		report.Deleted++
+	}
+	return report, nil
+}
+
+func (st *objectStore) migrateAlias(ctx context.Context, name, alias string, opts MigrationOptions) error {
+	var location CanonicalLocation
+	if err := st.readJSON(ctx, name, &location); err != nil {
+		return fmt.Errorf("error reading legacy alias %s: %v", name, err)
+	}
+	if opts.DryRun {
+		return nil
+	}
+	return st.StoreAlias(ctx, alias, location)
+}
+
+// migrateLocation rewrites location, read from the document name, and its
+// snapshot into the account ctx carries. It returns the key it was written
+// under, or why it was skipped.
+func (st *objectStore) migrateLocation(ctx context.Context, name string, location Location, opts MigrationOptions) (key, skipped string, err error) {
+	if location.Key == "" {
+		if opts.Resolve == nil {
+			return "", "location has no key and no resolver was given", nil
+		}
+		canonical, err := opts.Resolve(ctx, location.Name)
+		if errors.Is(err, ErrLocationNotFound) {
+			return "", err.Error(), nil
+		}
+		if err != nil {
+			return "", "", fmt.Errorf("error resolving legacy location %s: %w", location.Name, err)
+		}
+		location.Key = canonical.Key
+		location.Canonical = canonical
+	}
+	if location.Version == "" {
+		updatedAt := location.UpdatedAt
+		if updatedAt.IsZero() {
+			updatedAt = time.Now().UTC()
+		}
+		location.Version = updatedAt.Format(snapshotVersionFormat)
+	}
+
+	current, err := st.GetLocation(ctx, location.Key)
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return "", "", err
+	}
+	if current != nil && current.Version >= location.Version {
+		return "", fmt.Sprintf("%s already holds snapshot %s", location.Key, current.Version), nil
+	}
+
+	if opts.DryRun {
+		return location.Key, "", nil
+	}
+	if err := st.writeSnapshot(ctx, location); err != nil {
+		return "", "", err
+	}
+	if err := st.pruneSnapshots(ctx, location.Key, location.Version); err != nil {
+		return "", "", err
+	}
+	st.logger.Printf("Migrated location %s to %s", name, locationObjectName(accountScope(ctx), location.Key))
+	return location.Key, "", nil
+}
+
+// unscopedLocationKey returns the key of name if it is the document of an
+// unscoped location in the current layout.
+func unscopedLocationKey(name string) (string, bool) {
+	rest, ok := strings.CutPrefix(name, locationPrefix("", ""))
+	if !ok {
+		return "", false
+	}
+	key, ok := strings.CutSuffix(rest, "/"+legacyLocationObject)
+	return key, ok && key != "" && !strings.Contains(key, "/")
+}
+
+// legacyOwner returns the longest of the location prefixes name is under, so
+// the objects of a location named "a/b" aren't counted as those of "a".
+func legacyOwner(prefixes []string, name string) string {
+	owner := ""
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) && len(prefix) > len(owner) {
+			owner = prefix
+		}
+	}
+	return owner
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// putLegacy writes v as JSON under name, bypassing the key functions.
+func putLegacy(t *testing.T, store *MemoryStore, name string, v any) {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wc := store.backend.newWriter(context.Background(), name, "application/json")
+	wc.Write(data)
+	if err := wc.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateLayout(t *testing.T) {
+	ctx := context.Background()
+	austin := &CanonicalLocation{Key: "austin-tx-us", Name: "Austin, TX, USA"}
+	resolve := func(ctx context.Context, name string) (*CanonicalLocation, error) {
+		if strings.EqualFold(name, "Austin") {
+			return austin, nil
+		}
+		return nil, ErrLocationNotFound
+	}
+
+	newLegacyStore := func(t *testing.T) *MemoryStore {
+		store := NewMemoryStore(testLogger())
+		competitor := Competitor{Name: "Jump / Slide", Products: []Product{{Name: "Castle", Category: "bounce-house", Price: Price{Min: 150}}}}
+		putLegacy(t, store, "Austin/location.json", Location{Name: "Austin", Version: "20240101T000000.000000000Z", Competitors: []Competitor{competitor}})
+		putLegacy(t, store, "Austin/snapshots/20240101T000000.000000000Z/Jump / Slide/competitor", competitor)
+		putLegacy(t, store, "Atlantis/location.json", Location{Name: "Atlantis"})
+		// A product named "location" and locations whose names contain "/"
+		putLegacy(t, store, "Austin/snapshots/20240101T000000.000000000Z/Jump / Slide/bounce-house/location.json", Product{Name: "location"})
+		putLegacy(t, store, "Austin/Atlantis/location.json", Location{Name: "Austin/Atlantis"})
+		putLegacy(t, store, "Winston/Salem/location.json", Location{Key: "winston-salem-nc-us", Name: "Winston/Salem"})
+		putLegacy(t, store, "misc/location.json", Location{Name: "Elsewhere"})
+		putLegacy(t, store, "aliases/austin-texas.json", austin)
+		putLegacy(t, store, "files/upload.txt", "kept")
+		return store
+	}
+
+	t.Run("DryRun", func(t *testing.T) {
+		store := newLegacyStore(t)
+		report, err := store.MigrateLayout(ctx, MigrationOptions{Resolve: resolve, DryRun: true, DeleteLegacy: true})
+		if err != nil {
+			t.Fatalf("MigrateLayout: %v", err)
+		}
+		if report.Locations["Austin/"] != "austin-tx-us" {
+			t.Errorf("report = %+v, want Austin migrated", report)
+		}
+		names, _ := store.backend.list(ctx, LayoutVersion+"/")
+		if len(names) != 0 {
+			t.Errorf("dry run wrote %v", names)
+		}
+	})
+
+	t.Run("Migrate", func(t *testing.T) {
+		store := newLegacyStore(t)
+		report, err := store.MigrateLayout(ctx, MigrationOptions{Resolve: resolve, DeleteLegacy: true})
+		if err != nil {
+			t.Fatalf("MigrateLayout: %v", err)
+		}
+		for _, name := range []string{"Atlantis/location.json", "Austin/Atlantis/location.json", "misc/location.json"} {
+			if _, ok := report.Skipped[name]; !ok {
+				t.Errorf("Skipped = %v, want %s", report.Skipped, name)
+			}
+		}
+		if len(report.Skipped) != 3 {
+			t.Errorf("Skipped = %v, want the product left out", report.Skipped)
+		}
+		if report.Locations["Winston/Salem/"] != "winston-salem-nc-us" {
+			t.Errorf("Locations = %v, want Winston/Salem migrated", report.Locations)
+		}
+
+		location, err := store.GetLocation(ctx, "austin-tx-us")
+		if err != nil {
+			t.Fatalf("GetLocation: %v", err)
+		}
+		if location.Version != "20240101T000000.000000000Z" || location.Canonical == nil || len(location.Competitors) != 1 {
+			t.Errorf("migrated location = %+v", location)
+		}
+		competitor := location.Competitors[0]
+		product := competitor.Products[0]
+		for _, name := range []string{
+			competitorObjectName("", "austin-tx-us", location.Version, competitor),
+			productObjectName("", "austin-tx-us", location.Version, competitor, product),
+		} {
+			if _, err := store.backend.newReader(ctx, name); err != nil {
+				t.Errorf("missing %s: %v", name, err)
+			}
+		}
+		if _, err := store.GetAlias(ctx, "austin-texas"); err != nil {
+			t.Errorf("alias not migrated: %v", err)
+		}
+
+		// The skipped location below Austin isn't deleted with it
+		legacy, _ := store.backend.list(ctx, "Austin/")
+		if len(legacy) != 1 || legacy[0] != "Austin/Atlantis/location.json" {
+			t.Errorf("legacy objects left behind: %v", legacy)
+		}
+		for _, kept := range []string{"Atlantis/location.json", "misc/location.json", "files/upload.txt"} {
+			if _, err := store.backend.newReader(ctx, kept); err != nil {
+				t.Errorf("%s was deleted", kept)
+			}
+		}
+
+		// Running again finds nothing newer to copy
+		report, err = store.MigrateLayout(ctx, MigrationOptions{Resolve: resolve})
+		if err != nil {
+			t.Fatalf("second MigrateLayout: %v", err)
+		}
+		if len(report.Locations) != 0 {
+			t.Errorf("second run migrated %v", report.Locations)
+		}
+	})
+
+	t.Run("IntoAccount", func(t *testing.T) {
+		store := newLegacyStore(t)
+		// Stored unscoped in the current layout before authentication
+		if err := store.StoreSnapshot(ctx, Location{Key: "dallas-tx-us", Name: "Dallas", Competitors: []Competitor{{Name: "Big Bounce"}}}); err != nil {
+			t.Fatal(err)
+		}
+
+		alice := ContextWithAccount(ctx, Account{UserID: "alice"})
+		report, err := store.MigrateLayout(ctx, MigrationOptions{Resolve: resolve, DeleteLegacy: true, Account: &Account{UserID: "alice"}})
+		if err != nil {
+			t.Fatalf("MigrateLayout: %v", err)
+		}
+		if report.Locations[locationPrefix("", "dallas-tx-us")+"/"] != "dallas-tx-us" {
+			t.Errorf("Locations = %v, want the unscoped location", report.Locations)
+		}
+
+		for _, key := range []string{"austin-tx-us", "dallas-tx-us"} {
+			location, err := store.GetLocation(alice, key)
+			if err != nil || len(location.Competitors) != 1 {
+				t.Errorf("GetLocation(%s) as alice = %+v, %v", key, location, err)
+			}
+			if _, err := store.GetLocation(ctx, key); !errors.Is(err, ErrObjectNotFound) {
+				t.Errorf("unscoped %s left behind: %v", key, err)
+			}
+		}
+		if _, err := store.GetLocation(ContextWithAccount(ctx, Account{UserID: "bob"}), "dallas-tx-us"); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("GetLocation as bob error = %v, want ErrObjectNotFound", err)
+		}
+	})
+}
@@ -0,0 +1,538 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+)
+
+// testStore runs the conformance suite every Store backend must pass.
+func testStore(t *testing.T, newStore func(t *testing.T) Store) {
+	ctx := context.Background()
+
+	t.Run("UploadDownload", func(t *testing.T) {
+		store := newStore(t)
+		dir := t.TempDir()
+		src := filepath.Join(dir, "src.txt")
+		if err := os.WriteFile(src, []byte("hello"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := UploadFile(ctx, store, src, "files/hello.txt"); err != nil {
+			t.Fatalf("UploadFile: %v", err)
+		}
+		dest := filepath.Join(dir, "dest.txt")
+		if err := DownloadFile(ctx, store, "files/hello.txt", dest); err != nil {
+			t.Fatalf("DownloadFile: %v", err)
+		}
+		got, err := os.ReadFile(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "hello" {
+			t.Errorf("downloaded %q, want %q", got, "hello")
+		}
+	})
+
+	t.Run("DownloadMissing", func(t *testing.T) {
+		store := newStore(t)
+		dest := filepath.Join(t.TempDir(), "out")
+		err := DownloadFile(ctx, store, "files/missing.txt", dest)
+		if !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("DownloadFile error = %v, want ErrObjectNotFound", err)
+		}
+		if _, err := os.Stat(dest); err == nil {
+			t.Error("DownloadFile created a file for a missing object")
+		}
+	})
+
+	t.Run("PutStatOpen", func(t *testing.T) {
+		store := newStore(t)
+		// A plain io.Reader is streamed without being retried
+		body := io.MultiReader(strings.NewReader("hello "), strings.NewReader("world"))
+		if err := store.PutObject(ctx, "uploads/hello.txt", "text/plain; charset=utf-8", body); err != nil {
+			t.Fatalf("PutObject: %v", err)
+		}
+
+		attrs, err := store.StatObject(ctx, "uploads/hello.txt")
+		if err != nil {
+			t.Fatalf("StatObject: %v", err)
+		}
+		if attrs.Size != 11 || !strings.HasPrefix(attrs.ContentType, "text/plain") || attrs.Updated.IsZero() {
+			t.Errorf("StatObject = %+v", attrs)
+		}
+
+		ranges := []struct {
+			offset, length int64
+			want           string
+		}{
+			{0, -1, "hello world"},
+			{6, -1, "world"},
+			{0, 5, "hello"},
+			{4, 3, "o w"},
+			{6, 100, "world"},
+		}
+		for _, r := range ranges {
+			rc, err := store.OpenObject(ctx, "uploads/hello.txt", r.offset, r.length)
+			if err != nil {
+				t.Fatalf("OpenObject(%d, %d): %v", r.offset, r.length, err)
+			}
+			got, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != r.want {
+				t.Errorf("OpenObject(%d, %d) read %q, want %q", r.offset, r.length, got, r.want)
+			}
+		}
+	})
+
+	t.Run("StatOpenMissing", func(t *testing.T) {
+		store := newStore(t)
+		if _, err := store.StatObject(ctx, "uploads/missing.txt"); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("StatObject error = %v, want ErrObjectNotFound", err)
+		}
+		if _, err := store.OpenObject(ctx, "uploads/missing.txt", 0, -1); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("OpenObject error = %v, want ErrObjectNotFound", err)
+		}
+	})
+
+	t.Run("FailedPutDiscardsObject", func(t *testing.T) {
+		store := newStore(t)
+		body := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("connection reset")))
+		if err := store.PutObject(ctx, "uploads/partial.txt", "text/plain", body); err == nil {
+			t.Fatal("PutObject succeeded with a failing reader")
+		}
+		if _, err := store.StatObject(ctx, "uploads/partial.txt"); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("StatObject error = %v, want the partial upload discarded", err)
+		}
+	})
+
+	t.Run("LocationRoundTrip", func(t *testing.T) {
+		store := newStore(t)
+		location := Location{
+			Key:  "austin-tx-us",
+			Name: "Austin, TX, US",
+			Competitors: []Competitor{{
+				Name:     "Jumpers",
+				Website:  "https://jumpers.example",
+				Products: []Product{{Name: "Castle", Price: Price{Min: 150, Max: 150}, Category: "bounce-house"}},
+			}},
+		}
+		if err := store.StoreLocation(ctx, location); err != nil {
+			t.Fatalf("StoreLocation: %v", err)
+		}
+
+		got, err := store.GetLocation(ctx, "austin-tx-us")
+		if err != nil {
+			t.Fatalf("GetLocation: %v", err)
+		}
+		if len(got.Competitors) != 1 || len(got.Competitors[0].Products) != 1 {
+			t.Fatalf("GetLocation returned %+v", got)
+		}
+		if got.Competitors[0].Products[0].Price.Min != 150 {
+			t.Errorf("price = %v, want 150", got.Competitors[0].Products[0].Price)
+		}
+	})
+
+	t.Run("GetLocationMissing", func(t *testing.T) {
+		store := newStore(t)
+		if _, err := store.GetLocation(ctx, "nowhere"); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("GetLocation error = %v, want ErrObjectNotFound", err)
+		}
+	})
+
+	t.Run("LocationsAreScopedPerAccount", func(t *testing.T) {
+		store := newStore(t)
+		alice := ContextWithAccount(ctx, Account{UserID: "alice"})
+		bob := ContextWithAccount(ctx, Account{UserID: "bob"})
+		if err := store.StoreSnapshot(alice, Location{Key: "austin-tx-us", Name: "Austin, TX, US"}); err != nil {
+			t.Fatalf("StoreSnapshot: %v", err)
+		}
+
+		if _, err := store.GetLocation(alice, "austin-tx-us"); err != nil {
+			t.Errorf("GetLocation for the owner: %v", err)
+		}
+		if _, err := store.GetLocation(bob, "austin-tx-us"); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("GetLocation for another account error = %v, want ErrObjectNotFound", err)
+		}
+		if _, err := store.GetLocation(ctx, "austin-tx-us"); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("GetLocation without account error = %v, want ErrObjectNotFound", err)
+		}
+	})
+
+	t.Run("StoreLocationWithoutKey", func(t *testing.T) {
+		store := newStore(t)
+		if err := store.StoreLocation(ctx, Location{Name: "Austin"}); err == nil {
+			t.Error("StoreLocation accepted a location without a key")
+		}
+	})
+
+	t.Run("AliasRoundTrip", func(t *testing.T) {
+		store := newStore(t)
+		if _, err := store.GetAlias(ctx, "austin-texas"); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("GetAlias error = %v, want ErrObjectNotFound", err)
+		}
+
+		location := CanonicalLocation{Key: "austin-tx-us", PlaceID: "austin", Name: "Austin, TX, USA", Center: LatLng{Lat: 30.2672, Lng: -97.7431}}
+		if err := store.StoreAlias(ctx, "austin-texas", location); err != nil {
+			t.Fatalf("StoreAlias: %v", err)
+		}
+		got, err := store.GetAlias(ctx, "austin-texas")
+		if err != nil {
+			t.Fatalf("GetAlias: %v", err)
+		}
+		if *got != location {
+			t.Errorf("GetAlias = %+v, want %+v", got, location)
+		}
+	})
+
+	t.Run("APIKeyRoundTrip", func(t *testing.T) {
+		store := newStore(t)
+		if _, err := store.GetAPIKey(ctx, "0123456789abcdef"); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("GetAPIKey error = %v, want ErrObjectNotFound", err)
+		}
+
+		key := APIKey{ID: "0123456789abcdef", Name: "Partner", Hash: "abc", Quotas: map[Operation]int64{OperationSearch: 10}}
+		if err := store.StoreAPIKey(ctx, key); err != nil {
+			t.Fatalf("StoreAPIKey: %v", err)
+		}
+		got, err := store.GetAPIKey(ctx, key.ID)
+		if err != nil {
+			t.Fatalf("GetAPIKey: %v", err)
+		}
+		if got.Hash != "abc" || got.Quotas[OperationSearch] != 10 {
+			t.Errorf("GetAPIKey = %+v", got)
+		}
+		keys, err := store.ListAPIKeys(ctx)
+		if err != nil {
+			t.Fatalf("ListAPIKeys: %v", err)
+		}
+		if len(keys) != 1 || keys[0].ID != key.ID {
+			t.Errorf("ListAPIKeys = %+v", keys)
+		}
+	})
+
+	t.Run("UpdateAPIKey", func(t *testing.T) {
+		store := newStore(t)
+		increment := func(key *APIKey) error {
+			key.Quotas[OperationSearch]++
+			return nil
+		}
+		if _, err := store.UpdateAPIKey(ctx, "0123456789abcdef", increment); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("UpdateAPIKey of a missing key error = %v, want ErrObjectNotFound", err)
+		}
+
+		key := APIKey{ID: "0123456789abcdef", Name: "Partner", Quotas: map[Operation]int64{OperationSearch: 0}}
+		if err := store.StoreAPIKey(ctx, key); err != nil {
+			t.Fatalf("StoreAPIKey: %v", err)
+		}
+
+		// Concurrent updates are all applied
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := store.UpdateAPIKey(ctx, key.ID, increment); err != nil {
+					t.Errorf("UpdateAPIKey: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		failed := errors.New("failed")
+		_, err := store.UpdateAPIKey(ctx, key.ID, func(key *APIKey) error {
+			key.Name = "Changed"
+			return failed
+		})
+		if !errors.Is(err, failed) {
+			t.Errorf("UpdateAPIKey error = %v, want the error of update", err)
+		}
+
+		got, err := store.GetAPIKey(ctx, key.ID)
+		if err != nil {
+			t.Fatalf("GetAPIKey: %v", err)
+		}
+		if got.Quotas[OperationSearch] != 8 || got.Name != "Partner" {
+			t.Errorf("GetAPIKey = %+v, want 8 increments and no failed update", got)
+		}
+	})
+
+	t.Run("ConditionalWrite", func(t *testing.T) {
+		backend := backendOf(t, newStore(t))
+		write := func(generation int64, data string) error {
+			wc := backend.newWriterIf(ctx, "cas.json", "application/json", generation)
+			io.WriteString(wc, data)
+			return wc.Close()
+		}
+
+		if err := write(0, "1"); err != nil {
+			t.Fatalf("creating write: %v", err)
+		}
+		data, generation, err := backend.readGeneration(ctx, "cas.json")
+		if err != nil || string(data) != "1" || generation == 0 {
+			t.Fatalf("readGeneration = %q, %d, %v", data, generation, err)
+		}
+		if err := write(0, "2"); !errors.Is(err, errGenerationMismatch) {
+			t.Errorf("creating write over an object error = %v, want errGenerationMismatch", err)
+		}
+		if err := write(generation, "2"); err != nil {
+			t.Fatalf("write at the current generation: %v", err)
+		}
+		if err := write(generation, "3"); !errors.Is(err, errGenerationMismatch) {
+			t.Errorf("write at a stale generation error = %v, want errGenerationMismatch", err)
+		}
+		if data, _, _ := backend.readGeneration(ctx, "cas.json"); string(data) != "2" {
+			t.Errorf("content = %q, want 2", data)
+		}
+	})
+
+	t.Run("SiteCacheRoundTrip", func(t *testing.T) {
+		store := newStore(t)
+		if _, err := store.GetSiteMap(ctx, "https://jumpers.example"); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("GetSiteMap error = %v, want ErrObjectNotFound", err)
+		}
+
+		siteMap := SiteMap{Website: "https://www.jumpers.example/", Pages: []string{"https://jumpers.example/rentals"}}
+		if err := store.StoreSiteMap(ctx, siteMap); err != nil {
+			t.Fatalf("StoreSiteMap: %v", err)
+		}
+		page := ScrapedPage{URL: "https://jumpers.example/rentals", ContentHash: "abc", Products: []Product{{Name: "Castle"}}}
+		if err := store.StorePage(ctx, siteMap.Website, page); err != nil {
+			t.Fatalf("StorePage: %v", err)
+		}
+
+		// Sites are keyed by domain and shared across accounts
+		other := ContextWithAccount(ctx, Account{UserID: "bob"})
+		gotMap, err := store.GetSiteMap(other, "http://jumpers.example")
+		if err != nil {
+			t.Fatalf("GetSiteMap: %v", err)
+		}
+		if len(gotMap.Pages) != 1 {
+			t.Errorf("GetSiteMap = %+v", gotMap)
+		}
+		gotPage, err := store.GetPage(other, "jumpers.example", page.URL)
+		if err != nil {
+			t.Fatalf("GetPage: %v", err)
+		}
+		if gotPage.ContentHash != "abc" || len(gotPage.Products) != 1 {
+			t.Errorf("GetPage = %+v", gotPage)
+		}
+		if _, err := store.GetPage(ctx, siteMap.Website, "https://jumpers.example/inventory"); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("GetPage of another page error = %v, want ErrObjectNotFound", err)
+		}
+	})
+
+	t.Run("StoreCompetitorAndProduct", func(t *testing.T) {
+		store := newStore(t)
+		competitor := Competitor{Name: "Jumpers / Party Co. ", Website: "https://jumpers.example"}
+		if err := store.StoreCompetitor(ctx, "austin-tx-us", "1", competitor); err != nil {
+			t.Fatalf("StoreCompetitor: %v", err)
+		}
+		product := Product{Name: "Castle 13'x13'", Category: "bounce-house", Price: Price{Min: 150, Max: 150}}
+		if err := store.StoreProduct(ctx, "austin-tx-us", "1", competitor, product); err != nil {
+			t.Fatalf("StoreProduct: %v", err)
+		}
+
+		names, err := backendOf(t, store).list(ctx, snapshotPrefix("", "austin-tx-us", "1"))
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		want := []string{competitorObjectName("", "austin-tx-us", "1", competitor), productObjectName("", "austin-tx-us", "1", competitor, product)}
+		if len(names) != 2 || !slices.Contains(names, want[0]) || !slices.Contains(names, want[1]) {
+			t.Errorf("objects = %v, want %v", names, want)
+		}
+	})
+
+	t.Run("SnapshotReplacesPrevious", func(t *testing.T) {
+		store := newStore(t)
+		first := Location{
+			Key:  "austin-tx-us",
+			Name: "Austin, TX, US",
+			Competitors: []Competitor{{
+				Name:     "Old Co",
+				Products: []Product{{Name: "Castle", Price: Price{Min: 100, Max: 100}}},
+			}},
+		}
+		if err := store.StoreSnapshot(ctx, first); err != nil {
+			t.Fatalf("StoreSnapshot: %v", err)
+		}
+		second := Location{
+			Key:  "austin-tx-us",
+			Name: "Austin, TX, US",
+			Competitors: []Competitor{{
+				Name:     "New Co",
+				Products: []Product{{Name: "Slide", Price: Price{Min: 200, Max: 200}}},
+			}},
+		}
+		if err := store.StoreSnapshot(ctx, second); err != nil {
+			t.Fatalf("StoreSnapshot: %v", err)
+		}
+
+		got, err := store.GetLocation(ctx, "austin-tx-us")
+		if err != nil {
+			t.Fatalf("GetLocation: %v", err)
+		}
+		if got.Version == "" || got.UpdatedAt.IsZero() {
+			t.Errorf("snapshot metadata not set: %+v", got)
+		}
+		if len(got.Competitors) != 1 || got.Competitors[0].Name != "New Co" {
+			t.Errorf("GetLocation returned %+v, want only New Co", got.Competitors)
+		}
+
+		names, err := backendOf(t, store).list(ctx, snapshotsPrefix("", "austin-tx-us"))
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		current := snapshotPrefix("", "austin-tx-us", got.Version) + "/"
+		for _, name := range names {
+			if !strings.HasPrefix(name, current) {
+				t.Errorf("stale object %s left behind", name)
+			}
+		}
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		return NewMemoryStore(testLogger())
+	})
+}
+
+func TestLocalStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		store, err := NewLocalStore(t.TempDir(), testLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
+
+func TestLocalStoreRejectsEscapingNames(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir(), testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(t.TempDir(), "src.txt")
+	if err := os.WriteFile(src, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := UploadFile(context.Background(), store, src, "../outside.txt"); err == nil {
+		t.Error("UploadFile accepted an object name outside the store root")
+	}
+}
+
+// TestFirebaseService runs the suite against a real bucket when
+// STORAGE_TEST_BUCKET and FIREBASE_CREDENTIALS_FILE are set.
+func TestFirebaseService(t *testing.T) {
+	bucket := os.Getenv("STORAGE_TEST_BUCKET")
+	credentials := os.Getenv("FIREBASE_CREDENTIALS_FILE")
+	if bucket == "" || credentials == "" {
+		t.Skip("STORAGE_TEST_BUCKET and FIREBASE_CREDENTIALS_FILE not set")
+	}
+
+	testStore(t, func(t *testing.T) Store {
+		store, err := NewFirebaseService(credentials, bucket, RetryPolicy{}, testLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Each subtest works under a prefix of its own and deletes what it
+		// wrote, so runs sharing the bucket don't see each other's objects.
+		id, err := newJobID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		gcs := store.backend
+		prefix := "store-test/" + id + "/"
+		store.backend = prefixedBackend{objectBackend: gcs, prefix: prefix}
+		t.Cleanup(func() {
+			ctx := context.Background()
+			names, err := gcs.list(ctx, prefix)
+			if err != nil {
+				t.Errorf("listing %s: %v", prefix, err)
+			}
+			for _, name := range names {
+				if err := gcs.delete(ctx, name); err != nil {
+					t.Errorf("deleting %s: %v", name, err)
+				}
+			}
+			if err := store.Close(); err != nil {
+				t.Error(err)
+			}
+		})
+		return store
+	})
+}
+
+// prefixedBackend keeps every object of backend under prefix.
+type prefixedBackend struct {
+	objectBackend
+	prefix string
+}
+
+func (b prefixedBackend) newWriter(ctx context.Context, name, contentType string) io.WriteCloser {
+	return b.objectBackend.newWriter(ctx, b.prefix+name, contentType)
+}
+
+func (b prefixedBackend) newWriterIf(ctx context.Context, name, contentType string, generation int64) io.WriteCloser {
+	return b.objectBackend.newWriterIf(ctx, b.prefix+name, contentType, generation)
+}
+
+func (b prefixedBackend) readGeneration(ctx context.Context, name string) ([]byte, int64, error) {
+	return b.objectBackend.readGeneration(ctx, b.prefix+name)
+}
+
+func (b prefixedBackend) newReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.objectBackend.newReader(ctx, b.prefix+name)
+}
+
+func (b prefixedBackend) newRangeReader(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	return b.objectBackend.newRangeReader(ctx, b.prefix+name, offset, length)
+}
+
+func (b prefixedBackend) stat(ctx context.Context, name string) (ObjectAttrs, error) {
+	attrs, err := b.objectBackend.stat(ctx, b.prefix+name)
+	attrs.Name = strings.TrimPrefix(attrs.Name, b.prefix)
+	return attrs, err
+}
+
+func (b prefixedBackend) list(ctx context.Context, prefix string) ([]string, error) {
+	names, err := b.objectBackend.list(ctx, b.prefix+prefix)
+	for i, name := range names {
+		names[i] = strings.TrimPrefix(name, b.prefix)
+	}
+	return names, err
+}
+
+func (b prefixedBackend) delete(ctx context.Context, name string) error {
+	return b.objectBackend.delete(ctx, b.prefix+name)
+}
+
+func backendOf(t *testing.T, store Store) objectBackend {
+	switch s := store.(type) {
+	case *MemoryStore:
+		return s.backend
+	case *LocalStore:
+		return s.backend
+	case *FirebaseService:
+		return s.backend
+	}
+	t.Fatalf("unknown store type %T", store)
+	return nil
+}
+
+func testLogger() *log.Logger {
+	return log.New(io.Discard, "", 0)
+}